@@ -0,0 +1,317 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/efucloud/common/messagebus"
+	"github.com/emicklei/go-restful/v3"
+	"gorm.io/gorm"
+	"io"
+	"k8s.io/klog/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuditEvent 审计事件
+type AuditEvent struct {
+	ID          string            `json:"id" description:"事件ID"`
+	RequestID   string            `json:"requestId,omitempty" description:"请求ID"`
+	Time        time.Time         `json:"time" description:"请求时间"`
+	Username    string            `json:"username,omitempty" description:"用户名"`
+	Org         string            `json:"org,omitempty" description:"组织"`
+	AppCode     string            `json:"appCode,omitempty" description:"应用编码"`
+	AppClientID string            `json:"appClientId,omitempty" description:"应用ClientID"`
+	ClientIP    string            `json:"clientIp" description:"客户端IP"`
+	UserAgent   string            `json:"userAgent,omitempty" description:"客户端UserAgent"`
+	Method      string            `json:"method" description:"请求方法"`
+	Route       string            `json:"route" description:"路由"`
+	Operation   string            `json:"operation,omitempty" description:"路由操作"`
+	Path        string            `json:"path" description:"请求路径"`
+	PathParams  map[string]string `json:"pathParams,omitempty" description:"路径参数"`
+	Status      int               `json:"status" description:"响应码"`
+	Latency     int64             `json:"latency" description:"耗时，毫秒"`
+	RequestBody string            `json:"requestBody,omitempty" description:"脱敏后的请求体"`
+}
+
+// AuditSink 审计事件的存储
+type AuditSink interface {
+	Write(ctx context.Context, event AuditEvent) error
+}
+
+// MessageBusAuditSink 将审计事件发布到消息总线，Topic 为空时使用 messagebus.TopicAudit
+// Publish 在订阅者队列满时阻塞且不响应 ctx，Auditor 在后台队列中写入，不会阻塞请求
+type MessageBusAuditSink struct {
+	Bus   messagebus.MessageBus
+	Topic string
+}
+
+func (s MessageBusAuditSink) Write(ctx context.Context, event AuditEvent) error {
+	topic := s.Topic
+	if len(topic) == 0 {
+		topic = messagebus.TopicAudit
+	}
+	s.Bus.Publish(topic, event)
+	return nil
+}
+
+// AuditEventModel GORM 审计事件表
+type AuditEventModel struct {
+	ID          string    `gorm:"type:varchar(64);primaryKey" json:"id"`
+	RequestID   string    `gorm:"type:varchar(128);index" json:"requestId"`
+	Time        time.Time `gorm:"index" json:"time"`
+	Username    string    `gorm:"type:varchar(255);index" json:"username"`
+	Org         string    `gorm:"type:varchar(255);index" json:"org"`
+	AppCode     string    `gorm:"type:varchar(255)" json:"appCode"`
+	AppClientID string    `gorm:"type:varchar(255)" json:"appClientId"`
+	ClientIP    string    `gorm:"type:varchar(64)" json:"clientIp"`
+	UserAgent   string    `gorm:"type:varchar(512)" json:"userAgent"`
+	Method      string    `gorm:"type:varchar(16)" json:"method"`
+	Route       string    `gorm:"type:varchar(512)" json:"route"`
+	Operation   string    `gorm:"type:varchar(255)" json:"operation"`
+	Path        string    `gorm:"type:varchar(2048)" json:"path"`
+	PathParams  string    `gorm:"type:text" json:"pathParams"`
+	Status      int       `json:"status"`
+	Latency     int64     `json:"latency"`
+	RequestBody string    `gorm:"type:longtext" json:"requestBody"`
+}
+
+func (AuditEventModel) TableName() string {
+	return "audit_events"
+}
+
+// GormAuditSink 将审计事件写入数据库
+type GormAuditSink struct {
+	db *gorm.DB
+}
+
+func NewGormAuditSink(db *gorm.DB) *GormAuditSink {
+	return &GormAuditSink{db: db}
+}
+
+// AutoMigrate 创建审计事件表
+func (s *GormAuditSink) AutoMigrate() error {
+	return s.db.AutoMigrate(&AuditEventModel{})
+}
+
+func (s *GormAuditSink) Write(ctx context.Context, event AuditEvent) error {
+	model := AuditEventModel{
+		ID: event.ID, RequestID: event.RequestID, Time: event.Time,
+		Username: event.Username, Org: event.Org, AppCode: event.AppCode, AppClientID: event.AppClientID,
+		ClientIP: event.ClientIP, UserAgent: event.UserAgent,
+		Method: event.Method, Route: event.Route, Operation: event.Operation, Path: event.Path,
+		Status: event.Status, Latency: event.Latency, RequestBody: event.RequestBody,
+	}
+	if len(event.PathParams) > 0 {
+		data, err := json.Marshal(event.PathParams)
+		if err != nil {
+			return err
+		}
+		model.PathParams = string(data)
+	}
+	return s.db.WithContext(ctx).Create(&model).Error
+}
+
+// AuditOptions 审计过滤器选项
+type AuditOptions struct {
+	// Sinks 审计事件写入的存储，按顺序写入
+	Sinks []AuditSink
+	// Methods 需要审计的方法，默认为 POST、PUT、PATCH 及 DELETE
+	Methods []string
+	// RecordBody 是否记录脱敏后的请求体
+	RecordBody bool
+	// MaxBodySize 记录的请求体最大字节数，默认 64KB
+	MaxBodySize int
+	// SensitiveFields 在 DefaultSensitiveParams 之外需要脱敏的字段
+	SensitiveFields []string
+	// Skip 返回 true 时不审计，如登录接口
+	Skip func(req *restful.Request) bool
+	// TrustedProxies 受信任的反向代理网段，用于从 X-Forwarded-For 获取客户端 IP
+	TrustedProxies []string
+	// WriteTimeout 写入审计事件的超时时间，默认 5s
+	WriteTimeout time.Duration
+	// QueueSize 等待写入的审计事件队列长度，默认 1024，队列满时丢弃事件并记录日志，避免存储变慢时阻塞请求
+	QueueSize int
+	// ClaimsAttributeKey 认证过滤器保存用户信息的属性键，默认为 DefaultAccountClaimsAttributeKey
+	ClaimsAttributeKey string
+}
+
+// Auditor 审计过滤器，请求处理完成后生成审计事件放入队列，由后台按顺序写入所有存储，写入失败只记录日志
+type Auditor struct {
+	options        AuditOptions
+	trustedProxies []*net.IPNet
+	redactor       *bodyRedactor
+	mu             sync.RWMutex
+	closed         bool
+	queue          chan AuditEvent
+	done           chan struct{}
+}
+
+// NewAuditor 创建审计过滤器并启动后台写入，停止服务时调用 Shutdown 等待队列中的事件写入完成
+//
+//	auditor, err := common.NewAuditor(common.AuditOptions{Sinks: []common.AuditSink{common.MessageBusAuditSink{Bus: bus}, common.NewGormAuditSink(db)}, RecordBody: true})
+//	container.Filter(auditor.Filter)
+//	server.AddShutdownHook("audit", auditor.Shutdown)
+func NewAuditor(options AuditOptions) (*Auditor, error) {
+	if len(options.Methods) == 0 {
+		options.Methods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = 64 * 1024
+	}
+	if options.WriteTimeout <= 0 {
+		options.WriteTimeout = 5 * time.Second
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = 1024
+	}
+	if len(options.ClaimsAttributeKey) == 0 {
+		options.ClaimsAttributeKey = DefaultAccountClaimsAttributeKey
+	}
+	trustedProxies, err := parseTrustedProxies(options.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	a := &Auditor{
+		options:        options,
+		trustedProxies: trustedProxies,
+		queue:          make(chan AuditEvent, options.QueueSize),
+		done:           make(chan struct{}),
+	}
+	if options.RecordBody {
+		a.redactor = newBodyRedactor(append(append([]string{}, DefaultSensitiveParams...), options.SensitiveFields...))
+	}
+	go a.run()
+	return a, nil
+}
+
+// AuditFilter 使用 NewAuditor 创建审计过滤器，需要在认证过滤器之后注册，才能获取到 AccountClaims
+// 无法在停止服务时等待队列写入完成，需要时使用 NewAuditor
+//
+//	filter, err := common.AuditFilter(common.AuditOptions{Sinks: []common.AuditSink{common.MessageBusAuditSink{Bus: bus}, common.NewGormAuditSink(db)}, RecordBody: true})
+func AuditFilter(options AuditOptions) (restful.FilterFunction, error) {
+	auditor, err := NewAuditor(options)
+	if err != nil {
+		return nil, err
+	}
+	return auditor.Filter, nil
+}
+
+// Filter 审计事件在 defer 中生成，处理过程中 panic 时同样会审计，状态记为 500
+// 未匹配到路由的请求不审计
+func (a *Auditor) Filter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	route := req.SelectedRoute()
+	if route == nil || !StringInArray(req.Request.Method, a.options.Methods) || (a.options.Skip != nil && a.options.Skip(req)) {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	start := time.Now()
+	event := AuditEvent{
+		ID:         NewID(),
+		Time:       start,
+		ClientIP:   ClientIP(req.Request, a.trustedProxies),
+		UserAgent:  req.Request.UserAgent(),
+		Method:     req.Request.Method,
+		Route:      route.Path(),
+		Operation:  route.Operation(),
+		Path:       req.Request.URL.Path,
+		PathParams: req.PathParameters(),
+	}
+	if a.redactor != nil && req.Request.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(req.Request.Body, int64(a.options.MaxBodySize)+1))
+		if err == nil {
+			req.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.Request.Body))
+			truncated := len(body) > a.options.MaxBodySize
+			if truncated {
+				body = body[:a.options.MaxBodySize]
+			}
+			event.RequestBody = a.redactor.redact(body, truncated)
+		}
+	}
+
+	completed := false
+	defer func() {
+		event.Status = resp.StatusCode()
+		if !completed {
+			event.Status = http.StatusInternalServerError
+		}
+		event.Latency = time.Since(start).Milliseconds()
+		event.RequestID = GetRequestIDFromReq(req)
+		// 认证过滤器可能在路由过滤器中执行，处理完成后再获取用户信息
+		if claims, exist := GetAccountClaimsFromReqAttribute(req, a.options.ClaimsAttributeKey); exist {
+			event.Username = claims.Username
+			event.Org = claims.Org
+			event.AppCode = claims.AppCode
+			event.AppClientID = claims.AppClientID
+		}
+		a.enqueue(event)
+	}()
+	chain.ProcessFilter(req, resp)
+	completed = true
+}
+
+// enqueue 不阻塞请求，队列满或已停止时丢弃事件
+func (a *Auditor) enqueue(event AuditEvent) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		klog.Errorf("audit event: %s dropped, auditor is shut down", event.ID)
+		return
+	}
+	select {
+	case a.queue <- event:
+	default:
+		klog.Errorf("audit event: %s dropped, queue is full", event.ID)
+	}
+}
+
+func (a *Auditor) run() {
+	defer close(a.done)
+	for event := range a.queue {
+		a.write(event)
+	}
+}
+
+func (a *Auditor) write(event AuditEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.options.WriteTimeout)
+	defer cancel()
+	for _, sink := range a.options.Sinks {
+		if err := sink.Write(ctx, event); err != nil {
+			klog.Errorf("write audit event: %s failed, err: %s", event.ID, err.Error())
+		}
+	}
+}
+
+// Shutdown 停止接收新的审计事件，等待队列中的事件写入完成，ctx 结束时返回 ctx.Err()
+func (a *Auditor) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
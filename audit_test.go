@@ -0,0 +1,179 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"github.com/efucloud/common/eauth"
+	"github.com/emicklei/go-restful/v3"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// auditSink 记录写入的事件，block 不为空时第一次写入前等待 block 关闭
+type auditSink struct {
+	mu      sync.Mutex
+	events  []AuditEvent
+	started chan struct{}
+	block   chan struct{}
+}
+
+func (s *auditSink) Write(ctx context.Context, event AuditEvent) error {
+	if s.block != nil {
+		s.started <- struct{}{}
+		<-s.block
+		s.block = nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *auditSink) written() []AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AuditEvent(nil), s.events...)
+}
+
+func newAuditContainer(t *testing.T, options AuditOptions) (*restful.Container, *Auditor) {
+	auditor, err := NewAuditor(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := new(restful.WebService)
+	ws.Route(ws.POST("/accounts/{name}").Operation("createAccount").To(func(req *restful.Request, resp *restful.Response) {
+		body, _ := io.ReadAll(req.Request.Body)
+		req.SetAttribute(DefaultAccountClaimsAttributeKey, &eauth.AccountClaims{Org: "efucloud", Username: "admin"})
+		if string(body) == "panic" {
+			panic("handler panic")
+		}
+		resp.WriteHeader(http.StatusCreated)
+		_, _ = resp.Write(body)
+	}))
+	container := restful.NewContainer()
+	container.DoNotRecover(false)
+	container.Add(ws)
+	container.Filter(auditor.Filter)
+	return container, auditor
+}
+
+func auditServe(container *restful.Container, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = "203.0.113.9:1234"
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAuditorEvent(t *testing.T) {
+	sink := &auditSink{}
+	container, auditor := newAuditContainer(t, AuditOptions{Sinks: []AuditSink{sink}, RecordBody: true, SensitiveFields: []string{"pin"}})
+	body := `{"username":"admin","password":"secret","pin":"1234"}`
+	if recorder := auditServe(container, http.MethodPost, "/accounts/admin", body); recorder.Body.String() != body {
+		t.Fatalf("handler should read the full body, got: %s", recorder.Body.String())
+	}
+	auditServe(container, http.MethodPost, "/accounts/admin", "panic")
+	auditServe(container, http.MethodGet, "/accounts/admin", "")
+	auditServe(container, http.MethodPost, "/missing", "")
+	if err := auditor.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	events := sink.written()
+	if len(events) != 2 {
+		t.Fatalf("only routed POST requests should be audited: %+v", events)
+	}
+	event := events[0]
+	if event.Status != http.StatusCreated || event.Route != "/accounts/{name}" || event.Operation != "createAccount" ||
+		event.PathParams["name"] != "admin" || event.ClientIP != "203.0.113.9" || event.Username != "admin" || event.Org != "efucloud" {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if expect := `{"username":"admin","password":"******","pin":"******"}`; event.RequestBody != expect {
+		t.Fatalf("request body: %s, expect: %s", event.RequestBody, expect)
+	}
+	if events[1].Status != http.StatusInternalServerError || events[1].Username != "admin" {
+		t.Fatalf("a panicking request should be audited as 500: %+v", events[1])
+	}
+}
+
+func TestAuditorSkipsRouteMiss(t *testing.T) {
+	sink := &auditSink{}
+	auditor, err := NewAuditor(AuditOptions{Sinks: []AuditSink{sink}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	processed := false
+	req := restful.NewRequest(httptest.NewRequest(http.MethodPost, "/missing", nil))
+	chain := &restful.FilterChain{Target: func(req *restful.Request, resp *restful.Response) { processed = true }}
+	auditor.Filter(req, restful.NewResponse(httptest.NewRecorder()), chain)
+	_ = auditor.Shutdown(context.Background())
+	if !processed || len(sink.written()) != 0 {
+		t.Fatalf("processed: %t, events: %+v", processed, sink.written())
+	}
+}
+
+func TestAuditorQueueFull(t *testing.T) {
+	sink := &auditSink{started: make(chan struct{}, 1), block: make(chan struct{})}
+	container, auditor := newAuditContainer(t, AuditOptions{Sinks: []AuditSink{sink}, QueueSize: 1})
+	start := time.Now()
+	auditServe(container, http.MethodPost, "/accounts/a", "")
+	// 第一个事件写入中，第二个事件在队列中，之后的事件被丢弃
+	<-sink.started
+	for _, name := range []string{"b", "c", "d"} {
+		auditServe(container, http.MethodPost, "/accounts/"+name, "")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("requests should not be blocked by a slow sink, elapsed: %s", elapsed)
+	}
+	close(sink.block)
+	if err := auditor.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	events := sink.written()
+	if len(events) != 2 || events[0].PathParams["name"] != "a" || events[1].PathParams["name"] != "b" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestAuditorShutdown(t *testing.T) {
+	sink := &auditSink{started: make(chan struct{}, 1), block: make(chan struct{})}
+	container, auditor := newAuditContainer(t, AuditOptions{Sinks: []AuditSink{sink}})
+	for _, name := range []string{"a", "b", "c"} {
+		auditServe(container, http.MethodPost, "/accounts/"+name, "")
+	}
+	<-sink.started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := auditor.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err: %v, shutdown should return when ctx is done", err)
+	}
+	// 停止后的事件被丢弃，已在队列中的事件继续写入
+	auditServe(container, http.MethodPost, "/accounts/d", "")
+	close(sink.block)
+	if err := auditor.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if events := sink.written(); len(events) != 3 {
+		t.Fatalf("queued events should be drained: %+v", events)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/ghodss/yaml"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// DefaultBindMaxBodySize 请求体默认最大字节数
+const DefaultBindMaxBodySize = 1 << 20
+
+var (
+	// ErrRequestBodyInvalid 请求体为空或无法解析，包括未知字段
+	ErrRequestBodyInvalid = newLibraryError("requestBodyInvalid", http.StatusBadRequest)
+	// ErrRequestBodyTooLarge 请求体超过最大字节数
+	ErrRequestBodyTooLarge = newLibraryError("requestBodyTooLarge", http.StatusRequestEntityTooLarge)
+	// ErrUnsupportedMediaType 请求体的 Content-Type 不是 JSON 或 YAML
+	ErrUnsupportedMediaType = newLibraryError("unsupportedMediaType", http.StatusUnsupportedMediaType)
+	// ErrValidateFailed 参数校验失败，fields 为翻译后的 FiledValidFailed
+	ErrValidateFailed = newLibraryError("validateFailed", http.StatusBadRequest, "fields")
+)
+
+// BindOptions 请求体解析及校验选项
+type BindOptions struct {
+	// Validate 为空时创建新的 validator，字段名使用 json 标签
+	Validate *validator.Validate
+	// MaxBodySize 请求体最大字节数，默认 1MB
+	MaxBodySize int64
+	// AllowUnknownFields 是否允许请求体中包含结构体未定义的字段，默认不允许
+	AllowUnknownFields bool
+	// LanguageAttributeKey 语言在请求属性中的键，默认为 DefaultLanguageAttributeKey
+	LanguageAttributeKey string
+}
+
+// Binder 解析请求体并校验参数，创建后可并发使用
+type Binder struct {
+	options     BindOptions
+	translators map[string]ut.Translator
+}
+
+// NewBinder 创建 Binder，会在 Validate 上注册自定义校验及中英文翻译
+func NewBinder(options BindOptions) *Binder {
+	if options.Validate == nil {
+		options.Validate = validator.New()
+		options.Validate.RegisterTagNameFunc(TagNameFunc)
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = DefaultBindMaxBodySize
+	}
+	if len(options.LanguageAttributeKey) == 0 {
+		options.LanguageAttributeKey = DefaultLanguageAttributeKey
+	}
+	binder := &Binder{options: options, translators: make(map[string]ut.Translator)}
+	// 翻译在创建时注册，避免请求处理时修改 validator
+	for _, lang := range []string{I18nZH, I18nEN} {
+		binder.translators[lang] = LoadValidateTranslator(lang, options.Validate)
+	}
+	return binder
+}
+
+var (
+	defaultBinder     *Binder
+	defaultBinderOnce sync.Once
+)
+
+// BindAndValidate 使用默认选项解析请求体并校验参数，见 Binder.BindAndValidate
+func BindAndValidate(req *restful.Request, entity interface{}) ErrorData {
+	defaultBinderOnce.Do(func() {
+		defaultBinder = NewBinder(BindOptions{})
+	})
+	return defaultBinder.BindAndValidate(req, entity)
+}
+
+// BindAndValidate 将 JSON 或 YAML 请求体解析到 entity 并校验参数，entity 应为结构体指针
+// 失败时返回的 ErrorData 可直接用于 ResponseErrorMessage，成功时 ErrorData.IsNil 为 true
+// Content-Type 为空时按 JSON 解析，YAML 先转换为 JSON 再解析，因此字段名均使用 json 标签
+//
+//	var body CreateAccount
+//	if data := binder.BindAndValidate(req, &body); data.IsNotNil() {
+//		common.ResponseErrorMessage(ctx, req, resp, bundle, data)
+//		return
+//	}
+func (b *Binder) BindAndValidate(req *restful.Request, entity interface{}) ErrorData {
+	lang := GetLanguageFromReq(req, b.options.LanguageAttributeKey)
+	if err := b.bind(req.Request, entity); err != nil {
+		return NewErrorData(err, lang)
+	}
+	if err := b.validate(lang, entity); err != nil {
+		return NewErrorData(err, lang)
+	}
+	return ErrorData{Lang: lang}
+}
+
+func (b *Binder) bind(req *http.Request, entity interface{}) error {
+	isYAML := false
+	if contentType := req.Header.Get("Content-Type"); len(contentType) > 0 {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return ErrUnsupportedMediaType.Wrap(err, nil)
+		}
+		switch {
+		case mediaType == restful.MIME_JSON || strings.HasSuffix(mediaType, "+json"):
+		case mediaType == "application/yaml" || mediaType == "application/x-yaml" || mediaType == "text/yaml" || strings.HasSuffix(mediaType, "+yaml"):
+			isYAML = true
+		default:
+			return ErrUnsupportedMediaType.Wrap(fmt.Errorf("content type: %s is not supported", mediaType), nil)
+		}
+	}
+	if req.Body == nil {
+		return ErrRequestBodyInvalid.Wrap(errors.New("request body is empty"), nil)
+	}
+	data, err := io.ReadAll(io.LimitReader(req.Body, b.options.MaxBodySize+1))
+	if err != nil {
+		return ErrRequestBodyInvalid.Wrap(fmt.Errorf("read request body failed, err: %s", err.Error()), nil)
+	}
+	if int64(len(data)) > b.options.MaxBodySize {
+		return ErrRequestBodyTooLarge.Wrap(fmt.Errorf("request body exceeds %d bytes", b.options.MaxBodySize), nil)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return ErrRequestBodyInvalid.Wrap(errors.New("request body is empty"), nil)
+	}
+	if isYAML {
+		if data, err = yaml.YAMLToJSON(data); err != nil {
+			return ErrRequestBodyInvalid.Wrap(fmt.Errorf("convert yaml to json failed, err: %s", err.Error()), nil)
+		}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if !b.options.AllowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if err = decoder.Decode(entity); err != nil {
+		return ErrRequestBodyInvalid.Wrap(fmt.Errorf("decode request body failed, err: %s", err.Error()), nil)
+	}
+	if decoder.More() {
+		return ErrRequestBodyInvalid.Wrap(errors.New("request body must contain a single value"), nil)
+	}
+	return nil
+}
+
+// validate 只校验结构体，校验失败时返回翻译后的 FiledValidFailed
+func (b *Binder) validate(lang string, entity interface{}) error {
+	value := reflect.ValueOf(entity)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	err := b.options.Validate.Struct(entity)
+	if err == nil {
+		return nil
+	}
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+	trans, exist := b.translators[lang]
+	if !exist {
+		trans = b.translators[I18nZH]
+	}
+	failed := FiledValidFailed(removeTopStruct(validationErrors.Translate(trans)))
+	return ErrValidateFailed.Wrap(failed, map[string]interface{}{"fields": failed.String()})
+}
@@ -23,12 +23,28 @@ const (
 	QueryTypeEqual       = "eq"
 	QueryTypeLike        = "like"
 	QueryTypeIn          = "in"
+	QueryTypeNotEqual    = "ne"
+	QueryTypeGreater     = "gt"
+	QueryTypeGreaterOrEq = "gte"
+	QueryTypeLess        = "lt"
+	QueryTypeLessOrEq    = "lte"
+	QueryTypeBetween     = "between"
+	QueryTypeNotIn       = "notIn"
+	QueryTypeIsNull      = "isNull"
+	QueryTypeNotNull     = "notNull"
+	QueryTypeStartsWith  = "startsWith"
+	QueryTypeEndsWith    = "endsWith"
 	ParamTypeString      = "string"
 	ParamTypeNumber      = "integer"
 	ParamTypeBool        = "bool"
 	ParamTypeStringSlice = "stringSlice"
 	ParamTypeNumberSlice = "numberSlice"
 )
+const (
+	FilterLogicAnd = "and"
+	FilterLogicOr  = "or"
+	FilterLogicNot = "not"
+)
 const (
 	I18nZH = "zh"
 	I18nEN = "en"
@@ -0,0 +1,308 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/emicklei/go-restful/v3"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// ErrCursorInvalid 游标格式错误、签名不匹配或与当前排序不一致
+var ErrCursorInvalid = newLibraryError("cursorInvalid", http.StatusBadRequest)
+
+// Cursor 游标内容，Values 与排序字段一一对应
+type Cursor struct {
+	Order    string            `json:"o"`
+	Values   []json.RawMessage `json:"v"`
+	Backward bool              `json:"b,omitempty"`
+}
+
+// CursorCodec 使用 HMAC-SHA256 对游标签名，防止客户端篡改
+type CursorCodec struct {
+	secret []byte
+}
+
+// NewCursorCodec secret 为空时随机生成，此时游标只在当前进程内有效，多副本部署时需配置相同的 secret
+func NewCursorCodec(secret []byte) *CursorCodec {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+			panic(err)
+		}
+	}
+	return &CursorCodec{secret: secret}
+}
+
+func (c *CursorCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Encode 游标编码为不透明字符串
+func (c *CursorCodec) Encode(cursor Cursor) (token string, err error) {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(c.sign(payload)), nil
+}
+
+// Decode 校验签名并解析游标
+func (c *CursorCodec) Decode(token string) (cursor Cursor, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return cursor, ErrCursorInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return cursor, ErrCursorInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, c.sign(payload)) {
+		return cursor, ErrCursorInvalid
+	}
+	if err = json.Unmarshal(payload, &cursor); err != nil {
+		return cursor, ErrCursorInvalid
+	}
+	return cursor, nil
+}
+
+// CursorPagination 基于排序字段的游标分页，与 current/pageSize 分页并存，由接口自行选择
+type CursorPagination struct {
+	PageSize int
+	Sorts    SortList
+	Cursor   *Cursor
+	values   []interface{}
+	codec    *CursorCodec
+}
+
+// GetRequestCursorPagination 从请求中获取 cursor、pageSize 及 order 参数
+// 排序字段最后会追加 id 作为唯一键，保证翻页时顺序稳定，排序字段不能为 NULL
+// pageSize 最大为 DefaultMaxPageSize
+// 游标无效时返回 ErrCursorInvalid，排序参数无效时返回 ErrInvalidQueryParameter，均为 400
+func GetRequestCursorPagination(req *restful.Request, codec *CursorCodec, model interface{}) (page CursorPagination, err error) {
+	page.codec = codec
+	page.PageSize = String2Int(req.QueryParameter("pageSize"), DefaultPageSize)
+	if page.PageSize > DefaultMaxPageSize {
+		page.PageSize = DefaultMaxPageSize
+	}
+	page.Sorts, err = ParseSortSpec(req.QueryParameter("order"), model)
+	if err != nil {
+		return page, ErrInvalidQueryParameter.Wrap(err, nil)
+	}
+	page.Sorts = sortsWithTieBreaker(page.Sorts)
+	if token := req.QueryParameter("cursor"); len(token) > 0 {
+		cursor, er := codec.Decode(token)
+		if er != nil {
+			return page, er
+		}
+		if cursor.Order != page.Sorts.String() || len(cursor.Values) != len(page.Sorts) {
+			return page, ErrCursorInvalid
+		}
+		page.Cursor = &cursor
+		if page.values, err = cursorValues(cursor, page.Sorts, model); err != nil {
+			return page, err
+		}
+	}
+	return page, nil
+}
+
+// cursorValues 按模型字段类型还原游标中的值，如 time.Time
+func cursorValues(cursor Cursor, sorts SortList, model interface{}) (values []interface{}, err error) {
+	t := reflect.TypeOf(model)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	row := reflect.New(t)
+	for i, item := range sorts {
+		if field, ok := structFieldByJsonName(row, item.Field); ok {
+			value := reflect.New(field.Type())
+			if err = json.Unmarshal(cursor.Values[i], value.Interface()); err != nil {
+				return nil, ErrCursorInvalid
+			}
+			values = append(values, value.Elem().Interface())
+			continue
+		}
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader(cursor.Values[i]))
+		decoder.UseNumber()
+		if err = decoder.Decode(&value); err != nil {
+			return nil, ErrCursorInvalid
+		}
+		if number, ok := value.(json.Number); ok {
+			value = number.String()
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func sortsWithTieBreaker(sorts SortList) SortList {
+	for _, item := range sorts {
+		if item.Column == "id" {
+			return sorts
+		}
+	}
+	desc := true
+	if len(sorts) > 0 {
+		desc = sorts[len(sorts)-1].Desc
+	}
+	return append(sorts, SortField{Field: "id", Column: "id", Desc: desc})
+}
+
+// querySorts 向前翻页时需要反转排序方向
+func (p CursorPagination) querySorts() SortList {
+	if p.Cursor == nil || !p.Cursor.Backward {
+		return p.Sorts
+	}
+	sorts := make(SortList, len(p.Sorts))
+	for i, item := range p.Sorts {
+		item.Desc = !item.Desc
+		sorts[i] = item
+	}
+	return sorts
+}
+
+// Filter 游标对应的查询条件，如 (a > ?) OR (a = ? AND b > ?)
+func (p CursorPagination) Filter() Filter {
+	filter := FilterOr()
+	if p.Cursor == nil {
+		return filter
+	}
+	sorts := p.querySorts()
+	for i, item := range sorts {
+		group := FilterAnd()
+		for j := 0; j < i; j++ {
+			group.Add(FilterCondition(sorts[j].Column, QueryTypeEqual, p.values[j]))
+		}
+		operator := QueryTypeGreater
+		if item.Desc {
+			operator = QueryTypeLess
+		}
+		group.Add(FilterCondition(item.Column, operator, p.values[i]))
+		filter.Add(group)
+	}
+	return filter
+}
+
+// Scope 作为 GORM Scopes 使用，多查询一条数据用于判断是否还有下一页
+func (p CursorPagination) Scope() func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(p.Filter().Scope()).Clauses(p.querySorts().OrderBy()).Limit(p.PageSize + 1)
+	}
+}
+
+// Complete 处理 Scope 查询到的数据，rows 为切片指针，截掉多查询的数据并生成前后页游标
+func (p CursorPagination) Complete(rows interface{}) (nextCursor, prevCursor string, err error) {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Slice {
+		return "", "", fmt.Errorf("rows must be a pointer to slice, got %T", rows)
+	}
+	slice := value.Elem()
+	hasMore := slice.Len() > p.PageSize
+	if hasMore {
+		slice.Set(slice.Slice(0, p.PageSize))
+	}
+	backward := p.Cursor != nil && p.Cursor.Backward
+	if backward {
+		swap := reflect.Swapper(slice.Interface())
+		for i, j := 0, slice.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+	if slice.Len() == 0 {
+		return "", "", nil
+	}
+	if hasMore || backward {
+		if nextCursor, err = p.encodeRow(slice.Index(slice.Len()-1), false); err != nil {
+			return "", "", err
+		}
+	}
+	if (backward && hasMore) || (!backward && p.Cursor != nil) {
+		if prevCursor, err = p.encodeRow(slice.Index(0), true); err != nil {
+			return "", "", err
+		}
+	}
+	return nextCursor, prevCursor, nil
+}
+
+// after 返回从 row 之后继续查询的分页，用于服务端内部按批遍历，不需要编码游标
+func (p CursorPagination) after(row reflect.Value) (next CursorPagination, err error) {
+	next = CursorPagination{PageSize: p.PageSize, Sorts: p.Sorts, Cursor: &Cursor{Order: p.Sorts.String()}, codec: p.codec}
+	for _, item := range p.Sorts {
+		field, ok := structFieldByJsonName(row, item.Field)
+		if !ok {
+			return next, fmt.Errorf("cursor field: %s not found in %s", item.Field, row.Type())
+		}
+		next.values = append(next.values, field.Interface())
+	}
+	return next, nil
+}
+
+func (p CursorPagination) encodeRow(row reflect.Value, backward bool) (token string, err error) {
+	cursor := Cursor{Order: p.Sorts.String(), Backward: backward}
+	for _, item := range p.Sorts {
+		field, ok := structFieldByJsonName(row, item.Field)
+		if !ok {
+			return "", fmt.Errorf("cursor field: %s not found in %s", item.Field, row.Type())
+		}
+		value, er := json.Marshal(field.Interface())
+		if er != nil {
+			return "", er
+		}
+		cursor.Values = append(cursor.Values, value)
+	}
+	return p.codec.Encode(cursor)
+}
+
+func structFieldByJsonName(value reflect.Value, name string) (field reflect.Value, ok bool) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return field, false
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return field, false
+	}
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if strings.SplitN(t.Field(i).Tag.Get("json"), ",", 2)[0] == name {
+			return value.Field(i), true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Anonymous && len(t.Field(i).Tag.Get("json")) == 0 {
+			if field, ok = structFieldByJsonName(value.Field(i), name); ok {
+				return field, true
+			}
+		}
+	}
+	return field, false
+}
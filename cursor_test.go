@@ -0,0 +1,179 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/emicklei/go-restful/v3"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type cursorModel struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+func cursorRequest(query url.Values) *restful.Request {
+	return restful.NewRequest(httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil))
+}
+
+func TestCursorCodec(t *testing.T) {
+	codec := NewCursorCodec([]byte("secret"))
+	cursor := Cursor{Order: "name ASC,id ASC", Values: []json.RawMessage{json.RawMessage(`"admin"`), json.RawMessage(`7`)}, Backward: true}
+	token, err := codec.Encode(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := codec.Decode(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, cursor) {
+		t.Fatalf("decoded: %+v, expect: %+v", decoded, cursor)
+	}
+
+	forged, _ := NewCursorCodec([]byte("other")).Encode(cursor)
+	payload, _ := json.Marshal(Cursor{Order: cursor.Order, Values: []json.RawMessage{json.RawMessage(`"root"`), json.RawMessage(`1`)}})
+	tampered := base64.RawURLEncoding.EncodeToString(payload) + "." + strings.SplitN(token, ".", 2)[1]
+	for _, item := range []string{forged, tampered, "", "abc", token + ".x", token[:len(token)-2]} {
+		if _, err = codec.Decode(item); !errors.Is(err, ErrCursorInvalid) {
+			t.Fatalf("token: %s should be rejected, err: %v", item, err)
+		}
+	}
+}
+
+func TestGetRequestCursorPaginationInvalid(t *testing.T) {
+	codec := NewCursorCodec([]byte("secret"))
+	token, _ := codec.Encode(Cursor{Order: "name ASC,id ASC", Values: []json.RawMessage{json.RawMessage(`"admin"`), json.RawMessage(`7`)}})
+	cases := []struct {
+		query url.Values
+		err   error
+	}{
+		{url.Values{"order": {"-name"}, "cursor": {token}}, ErrCursorInvalid},
+		{url.Values{"order": {"name"}, "cursor": {token + "x"}}, ErrCursorInvalid},
+		{url.Values{"order": {"password"}}, ErrInvalidQueryParameter},
+	}
+	for _, item := range cases {
+		_, err := GetRequestCursorPagination(cursorRequest(item.query), codec, &cursorModel{})
+		if !errors.Is(err, item.err) {
+			t.Fatalf("query: %s, err: %v, expect: %v", item.query.Encode(), err, item.err)
+		}
+		if data := NewErrorData(err, I18nZH); data.ResponseCode != http.StatusBadRequest {
+			t.Fatalf("query: %s, response code: %d", item.query.Encode(), data.ResponseCode)
+		}
+	}
+	if _, err := GetRequestCursorPagination(cursorRequest(url.Values{"order": {"name"}, "cursor": {token}}), codec, &cursorModel{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetRequestCursorPaginationPageSize(t *testing.T) {
+	codec := NewCursorCodec([]byte("secret"))
+	cases := map[string]int{"": DefaultPageSize, "abc": DefaultPageSize, "-3": 1, "30": 30, "100000": DefaultMaxPageSize}
+	for pageSize, expect := range cases {
+		page, err := GetRequestCursorPagination(cursorRequest(url.Values{"pageSize": {pageSize}}), codec, &cursorModel{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.PageSize != expect {
+			t.Fatalf("pageSize: %s, got: %d, expect: %d", pageSize, page.PageSize, expect)
+		}
+	}
+}
+
+// fetchCursorPage 在内存中模拟 CursorPagination.Scope 的查询，只支持按 id 排序
+func fetchCursorPage(rows []cursorModel, page CursorPagination) []cursorModel {
+	sorts := page.querySorts()
+	var result []cursorModel
+	for _, row := range rows {
+		if page.Cursor != nil {
+			cursor := page.values[0].(uint)
+			if (sorts[0].Desc && row.ID >= cursor) || (!sorts[0].Desc && row.ID <= cursor) {
+				continue
+			}
+		}
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if sorts[0].Desc {
+			return result[i].ID > result[j].ID
+		}
+		return result[i].ID < result[j].ID
+	})
+	if len(result) > page.PageSize+1 {
+		result = result[:page.PageSize+1]
+	}
+	return result
+}
+
+func TestCursorPaginationPages(t *testing.T) {
+	codec := NewCursorCodec([]byte("secret"))
+	var rows []cursorModel
+	for i := uint(1); i <= 8; i++ {
+		rows = append(rows, cursorModel{ID: i})
+	}
+	load := func(cursor string) (ids []uint, next, prev string) {
+		query := url.Values{"order": {"id"}, "pageSize": {"3"}}
+		if len(cursor) > 0 {
+			query.Set("cursor", cursor)
+		}
+		page, err := GetRequestCursorPagination(cursorRequest(query), codec, &cursorModel{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := fetchCursorPage(rows, page)
+		if next, prev, err = page.Complete(&data); err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range data {
+			ids = append(ids, row.ID)
+		}
+		return ids, next, prev
+	}
+	expect := func(name string, ids, expectIDs []uint, next, prev string, hasNext, hasPrev bool) {
+		if !reflect.DeepEqual(ids, expectIDs) || (len(next) > 0) != hasNext || (len(prev) > 0) != hasPrev {
+			t.Fatalf("%s: ids: %v, next: %t, prev: %t", name, ids, len(next) > 0, len(prev) > 0)
+		}
+	}
+	ids, next, prev := load("")
+	expect("first page", ids, []uint{1, 2, 3}, next, prev, true, false)
+	ids, next, prev = load(next)
+	expect("second page", ids, []uint{4, 5, 6}, next, prev, true, true)
+	secondNext := next
+	ids, next, prev = load(prev)
+	expect("back to first page", ids, []uint{1, 2, 3}, next, prev, true, false)
+	ids, next, prev = load(next)
+	expect("second page again", ids, []uint{4, 5, 6}, next, prev, true, true)
+	ids, next, prev = load(secondNext)
+	expect("last page", ids, []uint{7, 8}, next, prev, false, true)
+	ids, next, prev = load(prev)
+	expect("back from last page", ids, []uint{4, 5, 6}, next, prev, true, true)
+
+	page, _ := GetRequestCursorPagination(cursorRequest(url.Values{"order": {"id"}, "cursor": {prev}}), codec, &cursorModel{})
+	query, args, err := page.Filter().Build()
+	if err != nil || query != "((id < ?))" || !reflect.DeepEqual(args, []interface{}{uint(4)}) {
+		t.Fatalf("backward filter: %s, args: %v, err: %v", query, args, err)
+	}
+}
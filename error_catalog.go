@@ -0,0 +1,185 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultErrorMsgCode 非 CatalogError 转换为 ErrorData 时使用的 i18n 信息编码
+var DefaultErrorMsgCode = "statusInternalServerError"
+
+var (
+	errorDefinitions       = map[string]*ErrorDefinition{}
+	errorDefinitionsRWLock = sync.RWMutex{}
+)
+
+// ErrorDefinition 错误定义，MsgCode 为 i18n 信息编码，Status 为默认响应码，Params 为模板必需的参数
+// ErrorDefinition 实现了 error 接口，可用于 errors.Is(err, ErrXxx) 判断
+type ErrorDefinition struct {
+	MsgCode string
+	Status  int
+	Params  []string
+}
+
+// RegisterError 注册错误定义，应在包初始化时调用，重复注册同一个 MsgCode 会 panic
+//
+//	var ErrAccountNotFound = common.RegisterError("accountNotFound", http.StatusNotFound, "username")
+func RegisterError(msgCode string, status int, params ...string) *ErrorDefinition {
+	if len(msgCode) == 0 {
+		panic("error msgCode can not be empty")
+	}
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	errorDefinitionsRWLock.Lock()
+	defer errorDefinitionsRWLock.Unlock()
+	if _, exist := errorDefinitions[msgCode]; exist {
+		panic(fmt.Sprintf("error msgCode: %s is already registered", msgCode))
+	}
+	definition := &ErrorDefinition{MsgCode: msgCode, Status: status, Params: params}
+	errorDefinitions[msgCode] = definition
+	return definition
+}
+
+// newLibraryError 本库内置的错误定义，不注册到错误目录，避免与应用的错误编码冲突
+// 默认信息见 locales 目录，通过 AddDefaultMessages 加载
+func newLibraryError(msgCode string, status int, params ...string) *ErrorDefinition {
+	return &ErrorDefinition{MsgCode: msgCode, Status: status, Params: params}
+}
+
+// GetErrorDefinition 根据 MsgCode 获取错误定义
+func GetErrorDefinition(msgCode string) (definition *ErrorDefinition, exist bool) {
+	errorDefinitionsRWLock.RLock()
+	defer errorDefinitionsRWLock.RUnlock()
+	definition, exist = errorDefinitions[msgCode]
+	return definition, exist
+}
+
+// ErrorDefinitions 获取所有已注册的错误定义，按 MsgCode 排序
+func ErrorDefinitions() (definitions []*ErrorDefinition) {
+	errorDefinitionsRWLock.RLock()
+	for _, item := range errorDefinitions {
+		definitions = append(definitions, item)
+	}
+	errorDefinitionsRWLock.RUnlock()
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].MsgCode < definitions[j].MsgCode
+	})
+	return definitions
+}
+
+func (d *ErrorDefinition) Error() string {
+	return d.MsgCode
+}
+
+// New 创建错误，params 为 i18n 模板参数
+func (d *ErrorDefinition) New(params map[string]interface{}) *CatalogError {
+	return &CatalogError{Definition: d, Status: d.Status, Params: params}
+}
+
+// Wrap 包装底层错误，params 为 i18n 模板参数
+func (d *ErrorDefinition) Wrap(err error, params map[string]interface{}) *CatalogError {
+	return &CatalogError{Definition: d, Status: d.Status, Err: err, Params: params}
+}
+
+// CatalogError 基于错误定义创建的错误
+type CatalogError struct {
+	Definition *ErrorDefinition
+	Status     int
+	Err        error
+	Params     map[string]interface{}
+}
+
+func (e *CatalogError) Error() string {
+	if e.Err == nil {
+		return e.Definition.MsgCode
+	}
+	return fmt.Sprintf("%s: %s", e.Definition.MsgCode, e.Err.Error())
+}
+
+func (e *CatalogError) Unwrap() error {
+	return e.Err
+}
+
+// Is 与所属错误定义相同时返回 true
+func (e *CatalogError) Is(target error) bool {
+	definition, ok := target.(*ErrorDefinition)
+	return ok && definition == e.Definition
+}
+
+// WithStatus 覆盖默认响应码
+func (e *CatalogError) WithStatus(status int) *CatalogError {
+	e.Status = status
+	return e
+}
+
+// ErrorData 转换为 ResponseErrorMessage 使用的 ErrorData
+func (e *CatalogError) ErrorData(lang string) ErrorData {
+	return ErrorData{Lang: lang, ResponseCode: e.Status, Err: e, MsgCode: e.Definition.MsgCode, Params: e.Params}
+}
+
+// NewErrorData 将任意错误转换为 ErrorData，错误链中包含 CatalogError 时使用其定义，否则为 500 及 DefaultErrorMsgCode
+func NewErrorData(err error, lang string) ErrorData {
+	var catalogErr *CatalogError
+	if errors.As(err, &catalogErr) {
+		data := catalogErr.ErrorData(lang)
+		data.Err = err
+		return data
+	}
+	var definition *ErrorDefinition
+	if errors.As(err, &definition) {
+		return ErrorData{Lang: lang, ResponseCode: definition.Status, Err: err, MsgCode: definition.MsgCode}
+	}
+	return ErrorData{Lang: lang, ResponseCode: http.StatusInternalServerError, Err: err, MsgCode: DefaultErrorMsgCode}
+}
+
+// CheckErrorCatalog 检查所有已注册的错误编码在 bundle 的每个语言中都存在，且模板使用了必需的参数，应在服务启动时调用
+func CheckErrorCatalog(bundle *i18n.Bundle) error {
+	var errs []error
+	for _, tag := range bundle.LanguageTags() {
+		localizer := i18n.NewLocalizer(bundle, tag.String())
+		for _, definition := range ErrorDefinitions() {
+			params := make(map[string]interface{})
+			for _, param := range definition.Params {
+				params[param] = fmt.Sprintf("__%s__", param)
+			}
+			msg, msgTag, err := localizer.LocalizeWithTag(&i18n.LocalizeConfig{MessageID: definition.MsgCode, TemplateData: params})
+			var notFound *i18n.MessageNotFoundErr
+			if errors.As(err, &notFound) || msgTag != tag {
+				errs = append(errs, fmt.Errorf("locale: %s, msgCode: %s not found", tag, definition.MsgCode))
+				continue
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("locale: %s, msgCode: %s, err: %s", tag, definition.MsgCode, err.Error()))
+				continue
+			}
+			for _, param := range definition.Params {
+				if !strings.Contains(msg, fmt.Sprintf("__%s__", param)) {
+					errs = append(errs, fmt.Errorf("locale: %s, msgCode: %s, param: %s not used", tag, definition.MsgCode, param))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/emicklei/go-restful/v3"
+	"go.uber.org/zap"
+	"k8s.io/klog/v2"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+const (
+	// ErrorRenderDebug 返回错误详情及调用栈，便于开发调试
+	ErrorRenderDebug = "debug"
+	// ErrorRenderProduction 不返回错误详情及调用栈，只返回错误ID，详细信息记录到服务端日志
+	ErrorRenderProduction = "production"
+)
+
+// ErrorRenderPolicy ResponseErrorMessage 的错误渲染策略
+type ErrorRenderPolicy struct {
+	Mode string `json:"mode" yaml:"mode" description:"渲染模式: debug,production"`
+	// ExposeClientErrorDetail production 模式下 4xx 错误是否返回 Detail
+	ExposeClientErrorDetail bool `json:"exposeClientErrorDetail" yaml:"exposeClientErrorDetail" description:"是否返回4xx错误详情"`
+	// StackDepth debug 模式下返回的调用栈深度，默认为 5
+	StackDepth int `json:"stackDepth" yaml:"stackDepth" description:"调用栈深度"`
+	// Logger production 模式下记录错误的日志，为空时使用 klog，5xx 以 Error 级别记录调用栈，4xx 以 Info 级别记录
+	Logger *zap.SugaredLogger `json:"-" yaml:"-"`
+}
+
+var errorRenderPolicy = ErrorRenderPolicy{Mode: ErrorRenderDebug}
+
+// SetErrorRenderPolicy 设置全局错误渲染策略，应在服务启动时调用
+func SetErrorRenderPolicy(policy ErrorRenderPolicy) {
+	if len(policy.Mode) == 0 {
+		policy.Mode = ErrorRenderDebug
+	}
+	errorRenderPolicy = policy
+}
+
+// GetErrorRenderPolicy 获取当前错误渲染策略
+func GetErrorRenderPolicy() ErrorRenderPolicy {
+	return errorRenderPolicy
+}
+
+func (p ErrorRenderPolicy) isProduction() bool {
+	return p.Mode == ErrorRenderProduction
+}
+
+func (p ErrorRenderPolicy) stackDepth() int {
+	if p.StackDepth > 0 {
+		return p.StackDepth
+	}
+	return 5
+}
+
+// ErrorChain 按顺序展开错误链，支持 errors.Join 及 fmt.Errorf 多个 %w
+func ErrorChain(err error) (chain []string) {
+	if err == nil {
+		return nil
+	}
+	chain = append(chain, err.Error())
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		for _, item := range e.Unwrap() {
+			chain = append(chain, ErrorChain(item)...)
+		}
+	default:
+		chain = append(chain, ErrorChain(errors.Unwrap(err))...)
+	}
+	return chain
+}
+
+// callerStack 获取调用栈，skip 为 0 时从调用 callerStack 的函数开始
+func callerStack(skip int) (stack []string) {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		stack = append(stack, fmt.Sprintf("%s:%d %s", frame.File, frame.Line, frame.Function))
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// logErrorData production 模式下将完整错误链及调用栈以错误ID记录到服务端日志
+// 4xx 为客户端错误，如参数校验失败、限流等，只以 Info 级别记录错误链，不记录调用栈
+func (p ErrorRenderPolicy) logErrorData(req *restful.Request, errorID string, detail ErrorData, stack []string) {
+	chain := ErrorChain(detail.Err)
+	requestID := GetRequestIDFromReq(req)
+	if detail.ResponseCode < http.StatusInternalServerError {
+		if p.Logger != nil {
+			p.Logger.Infow("request failed", "errorId", errorID, "requestId", requestID, "method", req.Request.Method, "uri", req.Request.RequestURI,
+				"status", detail.ResponseCode, "msgCode", detail.MsgCode, "errors", chain)
+			return
+		}
+		klog.InfoS("request failed", "errorId", errorID, "requestId", requestID, "method", req.Request.Method, "uri", req.Request.RequestURI,
+			"status", detail.ResponseCode, "msgCode", detail.MsgCode, "errors", chain)
+		return
+	}
+	if p.Logger != nil {
+		p.Logger.Errorw("request failed", "errorId", errorID, "requestId", requestID, "method", req.Request.Method, "uri", req.Request.RequestURI,
+			"status", detail.ResponseCode, "msgCode", detail.MsgCode, "errors", chain, "stack", strings.Join(stack, "\n"))
+		return
+	}
+	klog.ErrorS(detail.Err, "request failed", "errorId", errorID, "requestId", requestID, "method", req.Request.Method, "uri", req.Request.RequestURI,
+		"status", detail.ResponseCode, "msgCode", detail.MsgCode, "errors", chain, "stack", strings.Join(stack, "\n"))
+}
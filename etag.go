@@ -0,0 +1,149 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"net/http"
+	"strings"
+)
+
+// ErrPreconditionFailed If-Match 与资源当前的 ETag 不匹配
+var ErrPreconditionFailed = newLibraryError("statusPreconditionFailed", http.StatusPreconditionFailed)
+
+// NewETag 根据资源版本生成强 ETag，如 updated_at 的时间戳或 resourceVersion
+func NewETag(version string) string {
+	return `"` + strings.ReplaceAll(version, `"`, "") + `"`
+}
+
+// ComputeETag 根据 JSON 序列化结果的 SHA-256 生成 ETag，与 ResponseSuccessETag 返回的 ETag 一致
+func ComputeETag(info interface{}) (etag string, err error) {
+	data, err := marshalResponseJSON(info)
+	if err != nil {
+		return "", err
+	}
+	return bodyETag(data), nil
+}
+
+// marshalResponseJSON 与 ResponseSuccess 使用的 resp.WriteAsJson 序列化方式一致，受 restful.PrettyPrintResponses 控制
+func marshalResponseJSON(info interface{}) ([]byte, error) {
+	if restful.PrettyPrintResponses {
+		return restful.MarshalIndent(info, "", " ")
+	}
+	var buf bytes.Buffer
+	if err := restful.NewEncoder(&buf).Encode(info); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func bodyETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return NewETag(hex.EncodeToString(sum[:16]))
+}
+
+// ETagMatch 判断 If-None-Match/If-Match 请求头是否包含 etag，weak 为 true 时使用弱比较
+func ETagMatch(header, etag string, weak bool) bool {
+	header = strings.TrimSpace(header)
+	if len(header) == 0 || len(etag) == 0 {
+		return false
+	}
+	if header == "*" {
+		return true
+	}
+	normalize := func(value string) (string, bool) {
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "W/") {
+			return value[2:], true
+		}
+		return value, false
+	}
+	target, targetWeak := normalize(etag)
+	for _, item := range strings.Split(header, ",") {
+		value, valueWeak := normalize(item)
+		if value == target && (weak || (!valueWeak && !targetWeak)) {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified GET/HEAD 请求的 If-None-Match 匹配 etag 时返回 304
+func notModified(req *restful.Request, resp *restful.Response, etag string) bool {
+	method := req.Request.Method
+	if method != http.MethodGet && method != http.MethodHead {
+		return false
+	}
+	if !ETagMatch(req.HeaderParameter("If-None-Match"), etag, true) {
+		return false
+	}
+	resp.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ResponseSuccessETag 与 ResponseSuccess 相同，返回响应体哈希生成的 ETag，If-None-Match 匹配时返回 304
+func ResponseSuccessETag(req *restful.Request, resp *restful.Response, info interface{}) {
+	if info == nil {
+		ResponseSuccess(resp, info)
+		return
+	}
+	data, err := marshalResponseJSON(info)
+	if err != nil {
+		ResponseSuccess(resp, info)
+		return
+	}
+	etag := bodyETag(data)
+	resp.AddHeader("ETag", etag)
+	if notModified(req, resp, etag) {
+		return
+	}
+	resp.AddHeader(restful.HEADER_ContentType, restful.MIME_JSON)
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(data)
+}
+
+// ResponseSuccessVersion 与 ResponseSuccess 相同，返回 version 生成的 ETag，If-None-Match 匹配时返回 304 且不序列化响应体
+//
+//	common.ResponseSuccessVersion(req, resp, strconv.FormatInt(item.UpdatedAt.UnixNano(), 10), item)
+func ResponseSuccessVersion(req *restful.Request, resp *restful.Response, version string, info interface{}) {
+	etag := NewETag(version)
+	resp.AddHeader("ETag", etag)
+	if notModified(req, resp, etag) {
+		return
+	}
+	ResponseSuccess(resp, info)
+}
+
+// CheckIfMatch 用于 PUT/DELETE 的乐观并发控制，etag 为资源当前的 ETag
+// 请求携带 If-Match 且不匹配时返回 412 并返回 false，未携带 If-Match 时返回 true
+//
+//	if !common.CheckIfMatch(ctx, req, resp, bundle, lang, common.NewETag(version)) {
+//		return
+//	}
+func CheckIfMatch(ctx context.Context, req *restful.Request, resp *restful.Response, bundle *i18n.Bundle, lang, etag string) bool {
+	header := req.HeaderParameter("If-Match")
+	if len(header) == 0 || ETagMatch(header, etag, false) {
+		return true
+	}
+	ResponseErrorMessage(ctx, req, resp, bundle, ErrPreconditionFailed.New(nil).ErrorData(lang))
+	return false
+}
@@ -0,0 +1,489 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"gorm.io/gorm"
+	"io"
+	"k8s.io/klog/v2"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatXLSX = "xlsx"
+)
+
+// DefaultExportBatchSize 导出时每批查询的行数
+const DefaultExportBatchSize = 500
+
+// GetRequestExportFormat 获取 format 查询参数，不是 csv/xlsx 时返回空字符串
+func GetRequestExportFormat(req *restful.Request) string {
+	switch format := strings.ToLower(req.QueryParameter("format")); format {
+	case ExportFormatCSV, ExportFormatXLSX:
+		return format
+	}
+	return ""
+}
+
+// ExportColumn 导出列，Title 来自 description 标签，为空时使用 json 名称
+type ExportColumn struct {
+	Name  string
+	Title string
+	// MsgCode 本地化列标题的 i18n 信息编码，来自 i18n 标签，为空时使用 Title
+	MsgCode string
+	index   []int
+}
+
+// GetStructExportColumns 按字段顺序获取导出列，展开匿名嵌入结构体，忽略 json:"-" 及 export:"-" 字段
+func GetStructExportColumns(model interface{}) (columns []ExportColumn) {
+	t := reflect.TypeOf(model)
+	if t == nil {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return structExportColumns(t, nil)
+}
+
+func structExportColumns(t reflect.Type, parent []int) (columns []ExportColumn) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		index := append(append([]int{}, parent...), i)
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || field.Tag.Get("export") == "-" {
+			continue
+		}
+		if field.Anonymous && len(name) == 0 {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				columns = append(columns, structExportColumns(embedded, index)...)
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		title := field.Tag.Get("description")
+		if len(title) == 0 {
+			title = name
+		}
+		columns = append(columns, ExportColumn{Name: name, Title: title, MsgCode: field.Tag.Get("i18n"), index: index})
+	}
+	return columns
+}
+
+// value 获取行中该列的值，嵌入的空指针返回 nil
+func (c ExportColumn) value(row reflect.Value) interface{} {
+	for _, i := range c.index {
+		for row.Kind() == reflect.Ptr {
+			if row.IsNil() {
+				return nil
+			}
+			row = row.Elem()
+		}
+		row = row.Field(i)
+	}
+	for row.Kind() == reflect.Ptr || row.Kind() == reflect.Interface {
+		if row.IsNil() {
+			return nil
+		}
+		row = row.Elem()
+	}
+	return row.Interface()
+}
+
+// exportCell 将值转换为单元格内容，数字保持原类型以便 xlsx 写入数字单元格
+func exportCell(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Local().Format("2006-01-02 15:04:05")
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice:
+		if rv.IsNil() {
+			return ""
+		}
+		data, _ := json.Marshal(value)
+		return string(data)
+	case reflect.Struct, reflect.Array:
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+	return fmt.Sprint(value)
+}
+
+func exportCellString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+type exportWriter interface {
+	WriteRow(cells []interface{}) error
+	Flush() error
+	Close() error
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVExportWriter(w io.Writer) (*csvExportWriter, error) {
+	// UTF-8 BOM，Excel 打开时才能正确识别中文
+	if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+		return nil, err
+	}
+	return &csvExportWriter{writer: csv.NewWriter(w)}, nil
+}
+
+func (w *csvExportWriter) WriteRow(cells []interface{}) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		record[i] = exportCellString(cell)
+		// 以 = + - @ 开头的字符串会被 Excel 当作公式执行
+		if _, ok := cell.(string); ok && len(record[i]) > 0 && strings.ContainsRune("=+-@\t\r", rune(record[i][0])) {
+			record[i] = "'" + record[i]
+		}
+	}
+	return w.writer.Write(record)
+}
+
+func (w *csvExportWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvExportWriter) Close() error {
+	return w.Flush()
+}
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxSheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetFooter = `</sheetData></worksheet>`
+)
+
+// xlsxExportWriter 流式写入只有一个工作表的 xlsx，字符串使用内联字符串，不需要缓存共享字符串表
+type xlsxExportWriter struct {
+	archive *zip.Writer
+	sheet   io.Writer
+}
+
+func newXLSXExportWriter(w io.Writer) (*xlsxExportWriter, error) {
+	archive := zip.NewWriter(w)
+	for _, part := range []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	} {
+		writer, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.WriteString(writer, part.content); err != nil {
+			return nil, err
+		}
+	}
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.WriteString(sheet, xlsxSheetHeader); err != nil {
+		return nil, err
+	}
+	return &xlsxExportWriter{archive: archive, sheet: sheet}, nil
+}
+
+func (w *xlsxExportWriter) WriteRow(cells []interface{}) error {
+	var builder strings.Builder
+	builder.WriteString("<row>")
+	for _, cell := range cells {
+		switch cell.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			builder.WriteString("<c><v>")
+			builder.WriteString(exportCellString(cell))
+			builder.WriteString("</v></c>")
+		case bool:
+			builder.WriteString(`<c t="b"><v>`)
+			if cell.(bool) {
+				builder.WriteString("1")
+			} else {
+				builder.WriteString("0")
+			}
+			builder.WriteString("</v></c>")
+		default:
+			builder.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			_ = xml.EscapeText(&builder, []byte(exportCellString(cell)))
+			builder.WriteString("</t></is></c>")
+		}
+	}
+	builder.WriteString("</row>")
+	_, err := io.WriteString(w.sheet, builder.String())
+	return err
+}
+
+func (w *xlsxExportWriter) Flush() error {
+	return w.archive.Flush()
+}
+
+func (w *xlsxExportWriter) Close() error {
+	if _, err := io.WriteString(w.sheet, xlsxSheetFooter); err != nil {
+		return err
+	}
+	return w.archive.Close()
+}
+
+// ExportOptions 导出选项
+type ExportOptions struct {
+	// ListOptions 与列表使用相同的查询条件，Cursor 及 SkipCount 不生效
+	ListOptions
+	// FileName 下载的文件名，不包含扩展名，默认为 export
+	FileName string
+	// Columns 导出列的 json 名称及顺序，为空时导出所有列
+	Columns []string
+	// BatchSize 每批查询的行数，默认为 DefaultExportBatchSize
+	BatchSize int
+	// MaxRows 最大导出行数，0 表示不限制
+	MaxRows int
+	// Bundle 用于本地化列标题，为空时使用 description 标签
+	Bundle *i18n.Bundle
+	// LanguageAttributeKey 请求语言的属性键，默认为 DefaultLanguageAttributeKey
+	LanguageAttributeKey string
+}
+
+func (o ExportOptions) columns(model interface{}) (columns []ExportColumn, err error) {
+	all := GetStructExportColumns(model)
+	if len(o.Columns) == 0 {
+		return all, nil
+	}
+	byName := make(map[string]ExportColumn, len(all))
+	for _, column := range all {
+		byName[column.Name] = column
+	}
+	for _, name := range o.Columns {
+		column, exist := byName[name]
+		if !exist {
+			return nil, fmt.Errorf("export column: %s is not exist", name)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// ExportResponse 按请求的查询条件及排序以游标分批查询，以 format 指定的 csv/xlsx 格式流式写入响应
+// 排序字段最后会追加 id 作为唯一键，排序字段不能为 NULL，ListOptions.Selects 需包含排序字段
+// 开始写入响应前发生的错误会返回，由调用方响应错误信息；开始写入后发生错误时记录日志并以 http.ErrAbortHandler 中断响应，
+// 客户端得到的是不完整的下载而不是看似完整的文件
+//
+//	if format := common.GetRequestExportFormat(req); len(format) > 0 {
+//		if err := common.ExportResponse[Account](ctx, db, req, resp, common.ExportOptions{ListOptions: listOptions, FileName: "accounts", Bundle: bundle}); err != nil {
+//			...
+//		}
+//		return
+//	}
+func ExportResponse[T any](ctx context.Context, db *gorm.DB, req *restful.Request, resp *restful.Response, options ExportOptions) (err error) {
+	var model T
+	format := GetRequestExportFormat(req)
+	if len(format) == 0 {
+		return fmt.Errorf("export format: %s is not supported", req.QueryParameter("format"))
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultExportBatchSize
+	}
+	if len(options.FileName) == 0 {
+		options.FileName = "export"
+	}
+	if len(options.LanguageAttributeKey) == 0 {
+		options.LanguageAttributeKey = DefaultLanguageAttributeKey
+	}
+	columns, err := options.columns(&model)
+	if err != nil {
+		return err
+	}
+	tx := db.WithContext(ctx).Model(&model)
+	if options.Query != nil {
+		filter, er := BindQueryFilter(req, options.Query)
+		if er != nil {
+			return er
+		}
+		tx = tx.Scopes(filter.Scope())
+	}
+	if options.Filter != nil {
+		tx = tx.Scopes(options.Filter.Scope())
+	}
+	sorts, err := ParseSortSpec(req.QueryParameter("order"), &model)
+	if err != nil {
+		return ErrInvalidQueryParameter.Wrap(err, nil)
+	}
+	if _, exist := GetStructSortColumns(&model)["id"]; !exist {
+		return fmt.Errorf("export model: %T has no id column for keyset pagination", model)
+	}
+	page := CursorPagination{Sorts: sortsWithTieBreaker(sorts)}
+
+	var (
+		writer   exportWriter
+		exported int
+	)
+	for {
+		limit := options.BatchSize
+		if options.MaxRows > 0 && exported+limit > options.MaxRows {
+			limit = options.MaxRows - exported
+		}
+		if limit <= 0 {
+			break
+		}
+		rows := make([]T, 0, limit)
+		if err = listSelect(tx.Session(&gorm.Session{}), options.ListOptions).
+			Scopes(page.Filter().Scope()).
+			Clauses(page.querySorts().OrderBy()).
+			Limit(limit).
+			Find(&rows).Error; err != nil {
+			break
+		}
+		if writer == nil {
+			if writer, err = startExport(req, resp, format, options, columns); err != nil {
+				return err
+			}
+		}
+		for i := range rows {
+			row := reflect.ValueOf(&rows[i])
+			cells := make([]interface{}, len(columns))
+			for j, column := range columns {
+				cells[j] = exportCell(column.value(row))
+			}
+			if err = writer.WriteRow(cells); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+		if err = writer.Flush(); err != nil {
+			break
+		}
+		resp.Flush()
+		exported += len(rows)
+		if len(rows) < limit {
+			break
+		}
+		if page, err = page.after(reflect.ValueOf(&rows[len(rows)-1])); err != nil {
+			break
+		}
+	}
+	if writer == nil {
+		return err
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		abortExport(req, err)
+	}
+	resp.Flush()
+	return nil
+}
+
+// abortExport 响应已开始写入，不能再写入错误信息，也不能正常结束响应，否则客户端会把截断的数据当作完整文件
+func abortExport(req *restful.Request, err error) {
+	klog.Errorf("export aborted, method: %s, uri: %s, err: %s", req.Request.Method, req.Request.RequestURI, err.Error())
+	panic(http.ErrAbortHandler)
+}
+
+// startExport 写入响应头及列标题
+func startExport(req *restful.Request, resp *restful.Response, format string, options ExportOptions, columns []ExportColumn) (writer exportWriter, err error) {
+	fileName := options.FileName + "." + format
+	disposition := fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`,
+		strings.NewReplacer(`"`, "", "\\", "").Replace(fileName), url.PathEscape(fileName))
+	resp.AddHeader("Content-Disposition", disposition)
+	switch format {
+	case ExportFormatCSV:
+		resp.AddHeader(restful.HEADER_ContentType, "text/csv; charset=utf-8")
+		writer, err = newCSVExportWriter(resp)
+	case ExportFormatXLSX:
+		resp.AddHeader(restful.HEADER_ContentType, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		writer, err = newXLSXExportWriter(resp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lang := GetLanguageFromReq(req, options.LanguageAttributeKey)
+	titles := make([]interface{}, len(columns))
+	for i, column := range columns {
+		titles[i] = column.Title
+		if options.Bundle != nil {
+			msgCode := column.MsgCode
+			if len(msgCode) == 0 {
+				msgCode = column.Title
+			}
+			if title, er := GetLocaleMessage(options.Bundle, nil, lang, msgCode); er == nil {
+				titles[i] = title
+			}
+		}
+	}
+	return writer, writer.WriteRow(titles)
+}
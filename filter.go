@@ -0,0 +1,247 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var filterFieldReg = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ErrInvalidQueryParameter 客户端提交的查询条件、排序或游标无效
+var ErrInvalidQueryParameter = newLibraryError("invalidQueryParameter", http.StatusBadRequest)
+
+// Filter 结构化查询条件
+// Logic 不为空时为分组节点，通过 and/or/not 组合 Children；否则为字段条件节点
+type Filter struct {
+	Logic    string      `json:"logic,omitempty" yaml:"logic,omitempty" description:"分组逻辑: and,or,not"`
+	Field    string      `json:"field,omitempty" yaml:"field,omitempty" description:"字段名称"`
+	Operator string      `json:"operator,omitempty" yaml:"operator,omitempty" description:"操作符: eq,ne,gt,gte,lt,lte,like,in,notIn,between,isNull,notNull,startsWith,endsWith"`
+	Value    interface{} `json:"value,omitempty" yaml:"value,omitempty" description:"查询值"`
+	Children []Filter    `json:"children,omitempty" yaml:"children,omitempty" description:"子条件"`
+}
+
+// FilterCondition 字段条件，field 为驼峰名称，渲染时转换为蛇形字段
+func FilterCondition(field, operator string, value interface{}) Filter {
+	return Filter{Field: field, Operator: operator, Value: value}
+}
+
+// FilterAnd 使用 AND 连接所有子条件
+func FilterAnd(children ...Filter) Filter {
+	return Filter{Logic: FilterLogicAnd, Children: children}
+}
+
+// FilterOr 使用 OR 连接所有子条件
+func FilterOr(children ...Filter) Filter {
+	return Filter{Logic: FilterLogicOr, Children: children}
+}
+
+// FilterNot 对子条件取反
+func FilterNot(child Filter) Filter {
+	return Filter{Logic: FilterLogicNot, Children: []Filter{child}}
+}
+
+// Add 向分组节点追加子条件
+func (f *Filter) Add(children ...Filter) {
+	f.Children = append(f.Children, children...)
+}
+
+// IsEmpty 分组节点没有任何有效子条件或字段条件没有字段名时为空
+func (f Filter) IsEmpty() bool {
+	if len(f.Logic) == 0 {
+		return len(f.Field) == 0
+	}
+	for _, child := range f.Children {
+		if !child.IsEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+// Build 渲染为带占位符的 SQL 条件及参数，空条件返回空字符串
+// 字段名只做格式校验后转换为蛇形字段，只能用于服务端构造的条件，客户端提交的条件应使用 BuildFor
+func (f Filter) Build() (query string, args []interface{}, err error) {
+	return f.build(nil)
+}
+
+// BuildFor 使用 model 的 json 字段作为白名单渲染条件，字段通过 GetStructSortColumns 映射为数据库字段
+// 用于客户端提交的高级查询，字段不存在或条件无效时返回 ErrInvalidQueryParameter
+func (f Filter) BuildFor(model interface{}) (query string, args []interface{}, err error) {
+	query, args, err = f.build(GetStructSortColumns(model))
+	if err != nil {
+		return "", nil, ErrInvalidQueryParameter.Wrap(err, nil)
+	}
+	return query, args, nil
+}
+
+// build columns 为空时不限制字段
+func (f Filter) build(columns map[string]string) (query string, args []interface{}, err error) {
+	if len(f.Logic) > 0 {
+		return f.buildGroup(columns)
+	}
+	if len(f.Field) == 0 {
+		return "", nil, nil
+	}
+	return f.buildCondition(columns)
+}
+
+func (f Filter) buildGroup(columns map[string]string) (query string, args []interface{}, err error) {
+	var sqlList []string
+	for _, child := range f.Children {
+		q, a, er := child.build(columns)
+		if er != nil {
+			return "", nil, er
+		}
+		if len(q) == 0 {
+			continue
+		}
+		sqlList = append(sqlList, fmt.Sprintf("(%s)", q))
+		args = append(args, a...)
+	}
+	if len(sqlList) == 0 {
+		return "", nil, nil
+	}
+	switch strings.ToLower(f.Logic) {
+	case FilterLogicAnd:
+		query = strings.Join(sqlList, " AND ")
+	case FilterLogicOr:
+		query = strings.Join(sqlList, " OR ")
+	case FilterLogicNot:
+		query = fmt.Sprintf("NOT (%s)", strings.Join(sqlList, " AND "))
+	default:
+		return "", nil, fmt.Errorf("filter logic: %s is not supported", f.Logic)
+	}
+	return query, args, nil
+}
+
+func (f Filter) buildCondition(columns map[string]string) (query string, args []interface{}, err error) {
+	var column string
+	if columns != nil {
+		var exist bool
+		if column, exist = columns[f.Field]; !exist {
+			return "", nil, fmt.Errorf("filter field: %s is not allowed", f.Field)
+		}
+	} else {
+		if !filterFieldReg.MatchString(f.Field) {
+			return "", nil, fmt.Errorf("filter field: %s is invalid", f.Field)
+		}
+		column = CamelString2Snake(f.Field)
+	}
+	// 与 NULL 比较永远不成立，eq/ne 渲染为 IS NULL / IS NOT NULL，其他比较操作符不允许为空
+	if f.Value == nil {
+		switch f.Operator {
+		case "", QueryTypeEqual:
+			return fmt.Sprintf("%s IS NULL", column), nil, nil
+		case QueryTypeNotEqual:
+			return fmt.Sprintf("%s IS NOT NULL", column), nil, nil
+		case QueryTypeGreater, QueryTypeGreaterOrEq, QueryTypeLess, QueryTypeLessOrEq, QueryTypeLike, QueryTypeStartsWith, QueryTypeEndsWith:
+			return "", nil, fmt.Errorf("filter field: %s, value of operator %s can not be null", f.Field, f.Operator)
+		}
+	}
+	switch f.Operator {
+	case "", QueryTypeEqual:
+		return fmt.Sprintf("%s = ?", column), []interface{}{f.Value}, nil
+	case QueryTypeNotEqual:
+		return fmt.Sprintf("%s <> ?", column), []interface{}{f.Value}, nil
+	case QueryTypeGreater:
+		return fmt.Sprintf("%s > ?", column), []interface{}{f.Value}, nil
+	case QueryTypeGreaterOrEq:
+		return fmt.Sprintf("%s >= ?", column), []interface{}{f.Value}, nil
+	case QueryTypeLess:
+		return fmt.Sprintf("%s < ?", column), []interface{}{f.Value}, nil
+	case QueryTypeLessOrEq:
+		return fmt.Sprintf("%s <= ?", column), []interface{}{f.Value}, nil
+	case QueryTypeLike:
+		return fmt.Sprintf("%s LIKE ?", column), []interface{}{fmt.Sprintf("%%%s%%", likeEscaper.Replace(fmt.Sprintf("%v", f.Value)))}, nil
+	case QueryTypeStartsWith:
+		return fmt.Sprintf("%s LIKE ?", column), []interface{}{fmt.Sprintf("%s%%", likeEscaper.Replace(fmt.Sprintf("%v", f.Value)))}, nil
+	case QueryTypeEndsWith:
+		return fmt.Sprintf("%s LIKE ?", column), []interface{}{fmt.Sprintf("%%%s", likeEscaper.Replace(fmt.Sprintf("%v", f.Value)))}, nil
+	case QueryTypeIn, QueryTypeNotIn:
+		values := reflect.ValueOf(f.Value)
+		if f.Value == nil || (values.Kind() != reflect.Slice && values.Kind() != reflect.Array) || values.Len() == 0 {
+			return "", nil, fmt.Errorf("filter field: %s, value of operator %s must be a non-empty slice", f.Field, f.Operator)
+		}
+		if f.Operator == QueryTypeIn {
+			return fmt.Sprintf("%s IN (?)", column), []interface{}{f.Value}, nil
+		}
+		return fmt.Sprintf("%s NOT IN (?)", column), []interface{}{f.Value}, nil
+	case QueryTypeBetween:
+		values := reflect.ValueOf(f.Value)
+		if f.Value == nil || (values.Kind() != reflect.Slice && values.Kind() != reflect.Array) || values.Len() != 2 {
+			return "", nil, fmt.Errorf("filter field: %s, value of operator %s must be a slice with two elements", f.Field, f.Operator)
+		}
+		return fmt.Sprintf("%s BETWEEN ? AND ?", column), []interface{}{values.Index(0).Interface(), values.Index(1).Interface()}, nil
+	case QueryTypeIsNull:
+		return fmt.Sprintf("%s IS NULL", column), nil, nil
+	case QueryTypeNotNull:
+		return fmt.Sprintf("%s IS NOT NULL", column), nil, nil
+	default:
+		return "", nil, fmt.Errorf("filter field: %s, operator: %s is not supported", f.Field, f.Operator)
+	}
+}
+
+// Expression 渲染为 GORM 条件表达式，可直接用于 db.Where / db.Clauses
+func (f Filter) Expression() (expression clause.Expression, err error) {
+	query, args, err := f.Build()
+	if err != nil {
+		return nil, err
+	}
+	return clause.Expr{SQL: query, Vars: args}, nil
+}
+
+// Scope 作为 GORM Scopes 使用，渲染失败时错误记录到 db.Error
+func (f Filter) Scope() func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		query, args, err := f.Build()
+		if err != nil {
+			_ = db.AddError(err)
+			return db
+		}
+		if len(query) == 0 {
+			return db
+		}
+		return db.Where(query, args...)
+	}
+}
+
+// ToQueryParam 以 AND 方式追加到 QueryParam，兼容已有的 db.Where(qp.WhereQuery, qp.WhereArgs...) 用法
+func (f Filter) ToQueryParam(queryParam *QueryParam) error {
+	query, args, err := f.Build()
+	if err != nil {
+		return err
+	}
+	if len(query) == 0 {
+		return nil
+	}
+	if queryParam.WhereQuery == "" {
+		queryParam.WhereQuery = fmt.Sprintf(" (%s) ", query)
+	} else {
+		queryParam.WhereQuery = fmt.Sprintf(" (%s) AND (%s) ", queryParam.WhereQuery, query)
+	}
+	queryParam.WhereArgs = append(queryParam.WhereArgs, args...)
+	return nil
+}
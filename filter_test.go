@@ -0,0 +1,107 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFilterBuild(t *testing.T) {
+	filter := FilterAnd(
+		FilterCondition("orgCode", QueryTypeEqual, "efucloud"),
+		FilterOr(
+			FilterCondition("username", QueryTypeStartsWith, "adm_"),
+			FilterCondition("createdAt", QueryTypeBetween, []string{"2023-01-01", "2023-12-31"}),
+		),
+		FilterNot(FilterCondition("status", QueryTypeIn, []uint{1, 2})),
+		FilterCondition("deletedAt", QueryTypeIsNull, nil),
+		FilterOr(),
+	)
+	query, args, err := filter.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectQuery := "(org_code = ?) AND ((username LIKE ?) OR (created_at BETWEEN ? AND ?)) AND (NOT ((status IN (?)))) AND (deleted_at IS NULL)"
+	if query != expectQuery {
+		t.Fatalf("query: %s, expect: %s", query, expectQuery)
+	}
+	expectArgs := []interface{}{"efucloud", `adm\_%`, "2023-01-01", "2023-12-31", []uint{1, 2}}
+	if !reflect.DeepEqual(args, expectArgs) {
+		t.Fatalf("args: %v, expect: %v", args, expectArgs)
+	}
+}
+
+func TestFilterBuildInvalid(t *testing.T) {
+	cases := []Filter{
+		FilterCondition("name;drop table user", QueryTypeEqual, "x"),
+		FilterCondition("name", "regexp", "x"),
+		FilterCondition("id", QueryTypeIn, []uint{}),
+		FilterCondition("id", QueryTypeBetween, []uint{1}),
+		{Logic: "xor", Children: []Filter{FilterCondition("id", QueryTypeEqual, 1)}},
+	}
+	for _, item := range cases {
+		if _, _, err := item.Build(); err == nil {
+			t.Fatalf("filter: %+v should be invalid", item)
+		}
+	}
+}
+
+func TestFilterToQueryParam(t *testing.T) {
+	var qp QueryParam
+	QueryEqual("org", "efucloud", &qp)
+	if err := FilterCondition("age", QueryTypeGreater, 18).ToQueryParam(&qp); err != nil {
+		t.Fatal(err)
+	}
+	if qp.WhereQuery != " ( org = ? ) AND (age > ?) " || len(qp.WhereArgs) != 2 {
+		t.Fatalf("unexpected query param: %+v", qp)
+	}
+}
+
+func TestFilterBuildNullValue(t *testing.T) {
+	query, args, err := FilterAnd(
+		FilterCondition("deletedAt", QueryTypeEqual, nil),
+		FilterCondition("updatedAt", QueryTypeNotEqual, nil),
+	).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "(deleted_at IS NULL) AND (updated_at IS NOT NULL)" || len(args) != 0 {
+		t.Fatalf("unexpected query: %s, args: %v", query, args)
+	}
+	if _, _, err = FilterCondition("age", QueryTypeGreater, nil).Build(); err == nil {
+		t.Fatal("gt with null value should be invalid")
+	}
+}
+
+type filterModel struct {
+	ID        uint   `json:"id"`
+	OrgCode   string `json:"orgCode" gorm:"column:org"`
+	Username  string `json:"username"`
+	Password  string `json:"-"`
+	Signature string `json:"signature" gorm:"-"`
+}
+
+func TestFilterBuildFor(t *testing.T) {
+	query, args, err := FilterOr(
+		FilterCondition("orgCode", QueryTypeEqual, "efucloud"),
+		FilterCondition("username", QueryTypeStartsWith, "adm"),
+	).BuildFor(&filterModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "(org = ?) OR (username LIKE ?)" || !reflect.DeepEqual(args, []interface{}{"efucloud", "adm%"}) {
+		t.Fatalf("unexpected query: %s, args: %v", query, args)
+	}
+	cases := []Filter{
+		FilterCondition("password", QueryTypeStartsWith, "$2a$1"),
+		FilterCondition("Password", QueryTypeEqual, "x"),
+		FilterCondition("signature", QueryTypeEqual, "x"),
+		FilterCondition("user.password", QueryTypeEqual, "x"),
+		FilterAnd(FilterCondition("id", QueryTypeEqual, 1), FilterNot(FilterCondition("org_code", QueryTypeEqual, "x"))),
+	}
+	for _, item := range cases {
+		if _, _, err = item.BuildFor(&filterModel{}); !errors.Is(err, ErrInvalidQueryParameter) {
+			t.Fatalf("filter: %+v should be rejected, err: %v", item, err)
+		}
+	}
+}
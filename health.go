@@ -0,0 +1,231 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"github.com/emicklei/go-restful/v3"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	HealthStatusOK           = "ok"
+	HealthStatusFailed       = "failed"
+	HealthStatusShuttingDown = "shuttingDown"
+)
+
+// HealthCheck 依赖检查，Optional 为 true 时检查失败不影响就绪状态
+type HealthCheck struct {
+	Name string
+	// Check 检查依赖是否可用，ctx 带有超时时间
+	Check func(ctx context.Context) error
+	// Timeout 为空时使用 HealthOptions.Timeout
+	Timeout  time.Duration
+	Optional bool
+}
+
+// HealthCheckResult 单个依赖的检查结果
+type HealthCheckResult struct {
+	Name     string `json:"name" description:"依赖名称"`
+	Status   string `json:"status" description:"检查结果"`
+	Error    string `json:"error,omitempty" description:"错误信息"`
+	Duration string `json:"duration" description:"检查耗时"`
+	Optional bool   `json:"optional,omitempty" description:"是否为可选依赖"`
+}
+
+// HealthReport 就绪检查报告
+type HealthReport struct {
+	Status string              `json:"status" description:"就绪状态"`
+	Checks []HealthCheckResult `json:"checks,omitempty" description:"依赖检查结果"`
+	Time   time.Time           `json:"time" description:"检查时间"`
+}
+
+// DatabaseHealthCheck 数据库 Ping 检查
+func DatabaseHealthCheck(name string, db *gorm.DB) HealthCheck {
+	return HealthCheck{Name: name, Check: func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}}
+}
+
+// HTTPHealthCheck 下游 HTTP 服务检查，响应码小于 400 时视为可用，client 为空时使用 DefaultHTTPClient
+func HTTPHealthCheck(name string, client *http.Client, address string) HealthCheck {
+	return HealthCheck{Name: name, Check: func(ctx context.Context) error {
+		response, err := RequestWithContext(ctx, client, http.MethodGet, address, nil, nil, nil, NoRetryPolicy())
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+		_ = response.Body.Close()
+		if response.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("response status: %d", response.StatusCode)
+		}
+		return nil
+	}}
+}
+
+// NewApplicationPublicInfo 由 ApplicationInfo 生成可公开的构建信息，不包含机器码及集群信息
+func NewApplicationPublicInfo(info ApplicationInfo) ApplicationPublicInfo {
+	return ApplicationPublicInfo{Application: info.Application, GoVersion: info.GoVersion, Commit: info.Commit, BuildDate: info.BuildDate}
+}
+
+// HealthOptions 健康检查服务选项
+type HealthOptions struct {
+	// RootPath WebService 的根路径，默认为 /
+	RootPath string
+	// Version /version 返回的构建信息
+	Version ApplicationPublicInfo
+	// Ready 服务是否就绪，如 Server.Ready，为空时视为就绪
+	Ready func() bool
+	// Timeout 单个依赖检查的默认超时时间，默认 5s
+	Timeout time.Duration
+	// HideErrors 不在报告中返回依赖检查的错误信息
+	HideErrors bool
+}
+
+// HealthService 提供 /healthz、/readyz 及 /version
+type HealthService struct {
+	options HealthOptions
+	mu      sync.RWMutex
+	checks  []HealthCheck
+}
+
+// NewHealthService 创建健康检查服务
+//
+//	health := common.NewHealthService(common.HealthOptions{Version: version, Ready: server.Ready})
+//	health.AddCheck(common.DatabaseHealthCheck("database", db))
+//	health.AddCheck(common.HealthCheck{Name: "messagebus", Check: pingMessageBus, Optional: true})
+//	container.Add(health.WebService())
+func NewHealthService(options HealthOptions, checks ...HealthCheck) *HealthService {
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Second
+	}
+	if len(options.RootPath) == 0 {
+		options.RootPath = "/"
+	}
+	return &HealthService{options: options, checks: checks}
+}
+
+// AddCheck 添加依赖检查
+func (h *HealthService) AddCheck(check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, check)
+}
+
+func (h *HealthService) runCheck(ctx context.Context, check HealthCheck) (result HealthCheckResult) {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = h.options.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	result = HealthCheckResult{Name: check.Name, Status: HealthStatusOK, Optional: check.Optional}
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("health check panic: %v", r)
+			}
+		}()
+		done <- check.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("health check timeout after %s", timeout)
+	}
+	result.Duration = time.Since(start).String()
+	if err != nil {
+		result.Status = HealthStatusFailed
+		if !h.options.HideErrors {
+			result.Error = err.Error()
+		}
+	}
+	return result
+}
+
+// Check 并发执行所有依赖检查，非可选依赖失败或服务未就绪时 Status 不为 ok
+func (h *HealthService) Check(ctx context.Context) (report HealthReport) {
+	h.mu.RLock()
+	checks := append([]HealthCheck{}, h.checks...)
+	h.mu.RUnlock()
+	report = HealthReport{Status: HealthStatusOK, Checks: make([]HealthCheckResult, len(checks)), Time: time.Now()}
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Checks[i] = h.runCheck(ctx, checks[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, result := range report.Checks {
+		if result.Status != HealthStatusOK && !result.Optional {
+			report.Status = HealthStatusFailed
+		}
+	}
+	if h.options.Ready != nil && !h.options.Ready() {
+		report.Status = HealthStatusShuttingDown
+	}
+	return report
+}
+
+func (h *HealthService) healthz(req *restful.Request, resp *restful.Response) {
+	_ = resp.WriteHeaderAndJson(http.StatusOK, HealthReport{Status: HealthStatusOK, Time: time.Now()}, restful.MIME_JSON)
+}
+
+func (h *HealthService) readyz(req *restful.Request, resp *restful.Response) {
+	report := h.Check(req.Request.Context())
+	status := http.StatusOK
+	if report.Status != HealthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	resp.AddHeader("Cache-Control", "no-store")
+	_ = resp.WriteHeaderAndJson(status, report, restful.MIME_JSON)
+}
+
+func (h *HealthService) version(req *restful.Request, resp *restful.Response) {
+	ResponseSuccess(resp, h.options.Version)
+}
+
+// WebService 创建 /healthz、/readyz 及 /version 路由
+func (h *HealthService) WebService() *restful.WebService {
+	ws := new(restful.WebService)
+	ws.Path(h.options.RootPath).Produces(restful.MIME_JSON)
+	ws.Route(ws.GET("/healthz").To(h.healthz).
+		Doc("存活检查，进程可以处理请求时返回 200").
+		Returns(http.StatusOK, "OK", HealthReport{}))
+	ws.Route(ws.GET("/readyz").To(h.readyz).
+		Doc("就绪检查，服务未就绪或依赖不可用时返回 503").
+		Returns(http.StatusOK, "OK", HealthReport{}).
+		Returns(http.StatusServiceUnavailable, "Service Unavailable", HealthReport{}))
+	ws.Route(ws.GET("/version").To(h.version).
+		Doc("构建信息").
+		Returns(http.StatusOK, "OK", ApplicationPublicInfo{}))
+	return ws
+}
@@ -17,18 +17,15 @@ limitations under the License.
 package common
 
 import (
-	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"github.com/emicklei/go-restful/v3"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"golang.org/x/net/http2"
-	"k8s.io/klog/v2"
 	"net"
 	"net/http"
 	"net/url"
@@ -53,6 +50,9 @@ func (a ApiInfo) Tags() []string {
 	return []string{a.Tag}
 }
 
+// GetRequestPaginationInformation order 参数未经过校验，不能直接用于 db.Order
+//
+// Deprecated: use GetRequestPaginationSorts instead.
 func GetRequestPaginationInformation(req *restful.Request) (current int, pageSize int, order string) {
 	current = String2Int(req.QueryParameter("current"), DefaultPage)
 	pageSize = String2Int(req.QueryParameter("pageSize"), DefaultPageSize)
@@ -68,6 +68,9 @@ type QueryParam struct {
 	WhereArgs  []interface{}
 }
 
+// CreateHttpClient 不校验服务端证书
+//
+// Deprecated: use NewHTTPClient instead.
 func CreateHttpClient(useHttp2 bool, timeout time.Duration) (client *http.Client) {
 	if useHttp2 {
 		client = &http.Client{
@@ -86,29 +89,21 @@ func CreateHttpClient(useHttp2 bool, timeout time.Duration) (client *http.Client
 	}
 	return client
 }
+
+// HttpRequest 不传递请求 ID 且不重试，需要跨服务追踪时使用 HttpRequestWithContext
 func HttpRequest(client *http.Client, method, address string, headers, cookies map[string]interface{}, queries url.Values, body []byte) (response *http.Response, err error) {
-	req, err := http.NewRequest(method, address, bytes.NewReader(body))
-	if err != nil {
-		err = fmt.Errorf("create http request failed, method: %s, address: %s, err: %s", method, address, err.Error())
-		klog.Error(err)
-		return response, err
-	}
-	for k, v := range headers {
-		req.Header.Add(k, fmt.Sprintf("%s", v))
-	}
-	for k, v := range cookies {
-		req.AddCookie(&http.Cookie{Name: k, Value: fmt.Sprintf("%s", v)})
-	}
-	req.URL.RawQuery = queries.Encode()
-	return client.Do(req)
+	return HttpRequestWithContext(context.Background(), client, method, address, headers, cookies, queries, body, NoRetryPolicy())
 }
 
 type ResponseError struct {
-	Message    string        `json:"message" yaml:"message" description:"错误英文编码"`
-	Detail     string        `json:"detail" yaml:"detail" description:"错误详情信息"`
-	Links      []ErrorSource `json:"links,omitempty" description:""`
-	Alert      string        `json:"alert" yaml:"alert" description:"支持I18N的提示信息"`
-	RequestURI string        `json:"requestUri" description:"当前请求地址"`
+	Message    string            `json:"message" yaml:"message" description:"错误英文编码"`
+	Detail     string            `json:"detail" yaml:"detail" description:"错误详情信息"`
+	Links      []ErrorSource     `json:"links,omitempty" description:""`
+	Alert      string            `json:"alert" yaml:"alert" description:"支持I18N的提示信息"`
+	RequestURI string            `json:"requestUri" description:"当前请求地址"`
+	ErrorID    string            `json:"errorId,omitempty" description:"错误ID，用于在服务端日志中查找错误详情"`
+	RequestID  string            `json:"requestId,omitempty" description:"请求ID，用于跨服务追踪请求"`
+	Fields     map[string]string `json:"fields,omitempty" description:"参数校验失败的字段及翻译后的提示信息"`
 }
 type ErrorSource struct {
 	File string `json:"file" description:""`
@@ -122,13 +117,13 @@ type AuthRedirectInfo struct {
 }
 
 type ResponseList struct {
-	Data  any   `json:"data" yaml:"data"`
-	Total int64 `json:"total" yaml:"total"`
+	Data       any    `json:"data" yaml:"data"`
+	Total      int64  `json:"total" yaml:"total"`
+	NextCursor string `json:"nextCursor,omitempty" yaml:"nextCursor,omitempty" description:"下一页游标，游标分页时返回"`
+	PrevCursor string `json:"prevCursor,omitempty" yaml:"prevCursor,omitempty" description:"上一页游标，游标分页时返回"`
 }
 
 func ResponseSuccess(resp *restful.Response, info interface{}) {
-	resp.Header().Add("X-Content-Type-Options", "nosniff")
-	resp.Header().Add("X-XSS-Protection", "1; mode=block")
 	_ = resp.WriteAsJson(info)
 
 }
@@ -145,30 +140,50 @@ func ResponseErrorMessage(ctx context.Context, req *restful.Request, resp *restf
 	if detail.ResponseCode == 0 {
 		detail.ResponseCode = http.StatusInternalServerError
 	}
-
-	resp.Header().Add("X-Content-Type-Options", "nosniff")
-	resp.Header().Add("X-XSS-Protection", "1; mode=block")
 	var body ResponseError
 	body.Message = detail.MsgCode
-	if detail.Err != nil {
-		body.Detail = detail.Err.Error()
-	}
-	depth := 1
-
-	for {
-		if _, file, line, ok := runtime.Caller(depth); ok {
-			body.Links = append(body.Links, ErrorSource{File: file, Line: line})
-		} else {
-			break
+	policy := GetErrorRenderPolicy()
+	if policy.isProduction() {
+		body.ErrorID = NewID()
+		if detail.Err != nil && policy.ExposeClientErrorDetail && detail.ResponseCode < http.StatusInternalServerError {
+			body.Detail = detail.Err.Error()
+		}
+		var stack []string
+		if detail.ResponseCode >= http.StatusInternalServerError {
+			stack = callerStack(1)
+		}
+		policy.logErrorData(req, body.ErrorID, detail, stack)
+	} else {
+		if detail.Err != nil {
+			body.Detail = detail.Err.Error()
 		}
-		depth += 1
-		if depth > 5 {
-			break
+		depth := 1
+
+		for {
+			if _, file, line, ok := runtime.Caller(depth); ok {
+				body.Links = append(body.Links, ErrorSource{File: file, Line: line})
+			} else {
+				break
+			}
+			depth += 1
+			if depth > policy.stackDepth() {
+				break
+			}
 		}
 	}
 
 	body.RequestURI = req.Request.RequestURI
+	body.RequestID = GetRequestIDFromReq(req)
+	// 校验失败的字段不受 Detail 是否返回的影响
+	var failed FiledValidFailed
+	if errors.As(detail.Err, &failed) {
+		body.Fields = failed
+	}
 	body.Alert, _ = GetLocaleMessage(bundle, detail.Params, detail.Lang, detail.MsgCode)
+	if AcceptProblemJSON(req) {
+		_ = resp.WriteHeaderAndJson(detail.ResponseCode, NewProblemDetails(detail.ResponseCode, body), MIME_PROBLEM_JSON)
+		return
+	}
 	_ = resp.WriteHeaderAndJson(detail.ResponseCode, body, restful.MIME_JSON)
 
 }
@@ -312,6 +327,9 @@ func TokenErr(resp *restful.Response, typ, description string, statusCode int) e
 	return nil
 }
 
+// Request 不校验服务端证书、不传递请求 ID 且不重试
+//
+// Deprecated: use RequestWithContext with NewHTTPClient instead.
 func Request(method, address string, headers map[string]string, queries map[string]interface{}, body interface{}) (response *http.Response, err error) {
 	client := &http.Client{
 		Transport: &http.Transport{
@@ -319,25 +337,7 @@ func Request(method, address string, headers map[string]string, queries map[stri
 		},
 		Timeout: 10 * time.Second,
 	}
-	b := new(bytes.Buffer)
-	err = json.NewEncoder(b).Encode(body)
-	if err != nil {
-		return nil, err
-	}
-	req, err := http.NewRequest(method, address, b)
-	if err != nil {
-		klog.Errorf("create request failed, err: %s", err.Error())
-		return nil, err
-	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
-	}
-	queryValues := url.Values{}
-	for k, v := range queries {
-		queryValues.Add(k, fmt.Sprintf("%v", v))
-	}
-	req.URL.RawQuery = queryValues.Encode()
-	return client.Do(req)
+	return RequestWithContext(context.Background(), client, method, address, headers, queries, body, NoRetryPolicy())
 }
 
 func NewHTTPClientWithCA(rootCA string, insecureSkipVerify bool) (client *http.Client, err error) {
@@ -386,9 +386,8 @@ func GetLangFromCtx(ctx context.Context, key string) (lang string) {
 	if len(key) == 0 {
 		key = "RequestLanguage"
 	}
-	lan := ctx.Value(key)
-	if lan != nil {
-		lang = lan.(string)
+	if lan, ok := ctx.Value(key).(string); ok && len(lan) > 0 {
+		lang = lan
 	}
 	return lang
 }
@@ -0,0 +1,278 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 熔断器打开时请求直接失败，可通过 errors.Is 判断
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState 熔断器状态
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// CircuitOpenError 熔断器打开时返回的错误
+type CircuitOpenError struct {
+	Host string
+	// RetryAfter 距离进入半开状态的剩余时间
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of host: %s is open, retry after: %s", e.Host, e.RetryAfter)
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitBreakerOptions 熔断器选项
+type CircuitBreakerOptions struct {
+	// FailureThreshold 连续失败次数达到该值时打开熔断器
+	FailureThreshold int `json:"failureThreshold" yaml:"failureThreshold" description:"连续失败次数阈值"`
+	// CoolDown 熔断器打开后进入半开状态的等待时间
+	CoolDown time.Duration `json:"coolDown" yaml:"coolDown" description:"熔断冷却时间"`
+	// HalfOpenMaxRequests 半开状态下允许同时进行的探测请求数
+	HalfOpenMaxRequests int `json:"halfOpenMaxRequests" yaml:"halfOpenMaxRequests" description:"半开状态最大探测请求数"`
+	// SuccessThreshold 半开状态下连续成功次数达到该值时关闭熔断器
+	SuccessThreshold int `json:"successThreshold" yaml:"successThreshold" description:"半开状态连续成功次数阈值"`
+	// FailureStatus 视为失败的响应码，为空时 5xx 视为失败
+	FailureStatus []int `json:"failureStatus" yaml:"failureStatus" description:"视为失败的响应码"`
+	// IdleTimeout 关闭状态且空闲超过该时间的主机会被移除，避免主机数量持续增长
+	IdleTimeout time.Duration `json:"idleTimeout" yaml:"idleTimeout" description:"空闲主机移除时间"`
+	// OnStateChange 状态变化时调用，在持有锁之外调用
+	OnStateChange func(host string, from, to CircuitState) `json:"-" yaml:"-"`
+}
+
+// DefaultCircuitBreakerOptions 连续失败 5 次打开，30s 后进入半开状态，空闲 10m 的主机会被移除
+func DefaultCircuitBreakerOptions() CircuitBreakerOptions {
+	return CircuitBreakerOptions{
+		FailureThreshold:    5,
+		CoolDown:            30 * time.Second,
+		HalfOpenMaxRequests: 1,
+		SuccessThreshold:    1,
+		IdleTimeout:         10 * time.Minute,
+	}
+}
+
+func (o CircuitBreakerOptions) failure(response *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	if len(o.FailureStatus) > 0 {
+		return IntInArray(response.StatusCode, o.FailureStatus)
+	}
+	return response.StatusCode >= http.StatusInternalServerError
+}
+
+type circuit struct {
+	state CircuitState
+	// generation 每次状态变化时递增，用于忽略在之前状态中放行的请求结果
+	generation uint64
+	failures   int
+	successes  int
+	probes     int
+	openedAt   time.Time
+	// inflight 已放行未完成的请求数，大于 0 时不会被移除
+	inflight int
+	lastUsed time.Time
+}
+
+// CircuitBreakerTransport 按请求主机熔断的 http.RoundTripper
+type CircuitBreakerTransport struct {
+	options CircuitBreakerOptions
+	next    http.RoundTripper
+	mu      sync.Mutex
+	hosts   map[string]*circuit
+	// swept 上次移除空闲主机的时间
+	swept time.Time
+}
+
+// NewCircuitBreakerTransport 创建熔断器，next 为空时使用 http.DefaultTransport
+func NewCircuitBreakerTransport(next http.RoundTripper, options CircuitBreakerOptions) *CircuitBreakerTransport {
+	defaults := DefaultCircuitBreakerOptions()
+	if options.FailureThreshold <= 0 {
+		options.FailureThreshold = defaults.FailureThreshold
+	}
+	if options.CoolDown <= 0 {
+		options.CoolDown = defaults.CoolDown
+	}
+	if options.HalfOpenMaxRequests <= 0 {
+		options.HalfOpenMaxRequests = defaults.HalfOpenMaxRequests
+	}
+	if options.SuccessThreshold <= 0 {
+		options.SuccessThreshold = defaults.SuccessThreshold
+	}
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = defaults.IdleTimeout
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &CircuitBreakerTransport{options: options, next: next, hosts: make(map[string]*circuit), swept: time.Now()}
+}
+
+// evictIdle 移除关闭状态且空闲超过 IdleTimeout 的主机，每个 IdleTimeout 最多执行一次，需持有锁
+// 打开及半开状态的主机会保留，避免丢失熔断状态
+func (t *CircuitBreakerTransport) evictIdle(now time.Time) {
+	if now.Sub(t.swept) < t.options.IdleTimeout {
+		return
+	}
+	t.swept = now
+	for host, c := range t.hosts {
+		if c.state == CircuitClosed && c.inflight == 0 && now.Sub(c.lastUsed) >= t.options.IdleTimeout {
+			delete(t.hosts, host)
+		}
+	}
+}
+
+// State 返回主机当前的熔断器状态
+func (t *CircuitBreakerTransport) State(host string) CircuitState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, exist := t.hosts[host]; exist {
+		if c.state == CircuitOpen && time.Since(c.openedAt) >= t.options.CoolDown {
+			return CircuitHalfOpen
+		}
+		return c.state
+	}
+	return CircuitClosed
+}
+
+func (t *CircuitBreakerTransport) notify(host string, from, to CircuitState) {
+	if from != to && t.options.OnStateChange != nil {
+		t.options.OnStateChange(host, from, to)
+	}
+}
+
+// setState 需持有锁
+func (t *CircuitBreakerTransport) setState(c *circuit, state CircuitState) {
+	c.state = state
+	c.generation++
+	c.failures = 0
+	c.successes = 0
+	c.probes = 0
+	if state == CircuitOpen {
+		c.openedAt = time.Now()
+	}
+}
+
+// allow 判断请求是否可以发送，返回判断前后的状态及放行时的 generation
+func (t *CircuitBreakerTransport) allow(host string) (from, to CircuitState, generation uint64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.evictIdle(now)
+	c, exist := t.hosts[host]
+	if !exist {
+		c = &circuit{}
+		t.hosts[host] = c
+	}
+	c.lastUsed = now
+	from = c.state
+	if c.state == CircuitOpen {
+		if wait := t.options.CoolDown - time.Since(c.openedAt); wait > 0 {
+			return from, c.state, c.generation, &CircuitOpenError{Host: host, RetryAfter: wait}
+		}
+		t.setState(c, CircuitHalfOpen)
+	}
+	if c.state == CircuitHalfOpen {
+		if c.probes >= t.options.HalfOpenMaxRequests {
+			return from, c.state, c.generation, &CircuitOpenError{Host: host}
+		}
+		c.probes++
+	}
+	c.inflight++
+	return from, c.state, c.generation, nil
+}
+
+// record 记录请求结果，canceled 为调用方主动取消，不计入成功或失败
+// 放行后状态已变化的请求结果会被忽略，如关闭状态放行的请求在半开状态完成时不能作为探测结果
+func (t *CircuitBreakerTransport) record(host string, generation uint64, failed, canceled bool) (from, to CircuitState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.hosts[host]
+	c.inflight--
+	c.lastUsed = time.Now()
+	from = c.state
+	if c.generation != generation {
+		return from, c.state
+	}
+	if canceled {
+		if c.state == CircuitHalfOpen {
+			c.probes--
+		}
+		return from, c.state
+	}
+	switch c.state {
+	case CircuitClosed:
+		if !failed {
+			c.failures = 0
+		} else if c.failures++; c.failures >= t.options.FailureThreshold {
+			t.setState(c, CircuitOpen)
+		}
+	case CircuitHalfOpen:
+		c.probes--
+		if failed {
+			t.setState(c, CircuitOpen)
+		} else if c.successes++; c.successes >= t.options.SuccessThreshold {
+			t.setState(c, CircuitClosed)
+		}
+	}
+	return from, c.state
+}
+
+func (t *CircuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	from, to, generation, err := t.allow(host)
+	t.notify(host, from, to)
+	if err != nil {
+		// 按 http.RoundTripper 的约定，未发送的请求也需要关闭请求体
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	response, err := t.next.RoundTrip(req)
+	from, to = t.record(host, generation, t.options.failure(response, err), errors.Is(err, context.Canceled))
+	t.notify(host, from, to)
+	return response, err
+}
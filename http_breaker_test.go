@@ -0,0 +1,208 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// breakerRoundTripper 按请求头 X-Status 返回响应码，X-Wait 不为空时等待 release 后返回
+type breakerRoundTripper struct {
+	release chan struct{}
+	started chan struct{}
+}
+
+func (r *breakerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("X-Wait")) > 0 {
+		r.started <- struct{}{}
+		<-r.release
+	}
+	if req.Header.Get("X-Status") == "canceled" {
+		return nil, context.Canceled
+	}
+	status := http.StatusOK
+	if req.Header.Get("X-Status") == "fail" {
+		status = http.StatusBadGateway
+	}
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+type breakerBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *breakerBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func newBreakerTransport(options CircuitBreakerOptions) (*CircuitBreakerTransport, *breakerRoundTripper, *[]string) {
+	next := &breakerRoundTripper{release: make(chan struct{}), started: make(chan struct{}, 8)}
+	var mu sync.Mutex
+	changes := make([]string, 0)
+	options.OnStateChange = func(host string, from, to CircuitState) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, host+":"+from.String()+"->"+to.String())
+	}
+	return NewCircuitBreakerTransport(next, options), next, &changes
+}
+
+func breakerRequest(host, status string, wait bool) *http.Request {
+	req, _ := http.NewRequest(http.MethodPost, "http://"+host+"/", &breakerBody{Reader: strings.NewReader("{}")})
+	req.Header.Set("X-Status", status)
+	if wait {
+		req.Header.Set("X-Wait", "true")
+	}
+	return req
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	transport, _, changes := newBreakerTransport(CircuitBreakerOptions{FailureThreshold: 2, CoolDown: 20 * time.Millisecond, SuccessThreshold: 2, HalfOpenMaxRequests: 2})
+	for _, status := range []string{"fail", "ok", "fail", "canceled", "fail"} {
+		_, _ = transport.RoundTrip(breakerRequest("a", status, false))
+	}
+	if state := transport.State("a"); state != CircuitOpen {
+		t.Fatalf("state: %s, expect open", state)
+	}
+	req := breakerRequest("a", "ok", false)
+	_, err := transport.RoundTrip(req)
+	var openErr *CircuitOpenError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &openErr) || openErr.RetryAfter <= 0 {
+		t.Fatalf("err: %v should be CircuitOpenError with RetryAfter", err)
+	}
+	if !req.Body.(*breakerBody).closed {
+		t.Fatal("body of a rejected request should be closed")
+	}
+	if state := transport.State("b"); state != CircuitClosed {
+		t.Fatalf("other hosts should not be affected, state: %s", state)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if state := transport.State("a"); state != CircuitHalfOpen {
+		t.Fatalf("state: %s, expect half-open after cool down", state)
+	}
+	_, _ = transport.RoundTrip(breakerRequest("a", "fail", false))
+	if state := transport.State("a"); state != CircuitOpen {
+		t.Fatalf("state: %s, a failed probe should open the circuit", state)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err = transport.RoundTrip(breakerRequest("a", "ok", false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state := transport.State("a"); state != CircuitClosed {
+		t.Fatalf("state: %s, expect closed after successful probes", state)
+	}
+	expect := []string{"a:closed->open", "a:open->half-open", "a:half-open->open", "a:open->half-open", "a:half-open->closed"}
+	if strings.Join(*changes, ",") != strings.Join(expect, ",") {
+		t.Fatalf("changes: %v, expect: %v", *changes, expect)
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	transport, next, _ := newBreakerTransport(CircuitBreakerOptions{FailureThreshold: 1, CoolDown: 10 * time.Millisecond})
+	_, _ = transport.RoundTrip(breakerRequest("a", "fail", false))
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := transport.RoundTrip(breakerRequest("a", "ok", true))
+		done <- err
+	}()
+	<-next.started
+	if _, err := transport.RoundTrip(breakerRequest("a", "ok", false)); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err: %v, only one probe should be admitted", err)
+	}
+	next.release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if state := transport.State("a"); state != CircuitClosed {
+		t.Fatalf("state: %s, expect closed", state)
+	}
+}
+
+func TestCircuitBreakerStaleGeneration(t *testing.T) {
+	transport, next, _ := newBreakerTransport(CircuitBreakerOptions{FailureThreshold: 1, CoolDown: 10 * time.Millisecond})
+	done := make(chan error, 2)
+	// 关闭状态放行的请求，在熔断器打开并进入半开状态后才成功
+	go func() {
+		_, err := transport.RoundTrip(breakerRequest("a", "ok", true))
+		done <- err
+	}()
+	<-next.started
+	_, _ = transport.RoundTrip(breakerRequest("a", "fail", false))
+	time.Sleep(20 * time.Millisecond)
+	// 半开状态放行的探测请求
+	go func() {
+		_, err := transport.RoundTrip(breakerRequest("a", "fail", true))
+		done <- err
+	}()
+	<-next.started
+
+	next.release <- struct{}{}
+	<-done
+	if state := transport.State("a"); state != CircuitHalfOpen {
+		t.Fatalf("state: %s, a stale success should not close the circuit", state)
+	}
+	next.release <- struct{}{}
+	<-done
+	if state := transport.State("a"); state != CircuitOpen {
+		t.Fatalf("state: %s, the probe failure should open the circuit", state)
+	}
+}
+
+func TestCircuitBreakerEvictIdle(t *testing.T) {
+	transport, next, _ := newBreakerTransport(CircuitBreakerOptions{FailureThreshold: 1, CoolDown: time.Minute, IdleTimeout: 20 * time.Millisecond})
+	_, _ = transport.RoundTrip(breakerRequest("idle", "ok", false))
+	_, _ = transport.RoundTrip(breakerRequest("open", "fail", false))
+	done := make(chan error, 1)
+	go func() {
+		_, err := transport.RoundTrip(breakerRequest("busy", "ok", true))
+		done <- err
+	}()
+	<-next.started
+
+	time.Sleep(30 * time.Millisecond)
+	_, _ = transport.RoundTrip(breakerRequest("new", "ok", false))
+	transport.mu.Lock()
+	_, idle := transport.hosts["idle"]
+	_, open := transport.hosts["open"]
+	_, busy := transport.hosts["busy"]
+	transport.mu.Unlock()
+	if idle || !open || !busy {
+		t.Fatalf("only idle closed hosts should be evicted, idle: %t, open: %t, busy: %t", idle, open, busy)
+	}
+	next.release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if state := transport.State("busy"); state != CircuitClosed {
+		t.Fatalf("state: %s, expect closed", state)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/efucloud/common/datatypes"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// HTTPClientOptions HTTP 客户端选项，默认校验服务端证书
+type HTTPClientOptions struct {
+	// CAData PEM 格式的 CA 证书，每一项可包含多个证书，也支持 base64 编码的 PEM
+	CAData []string `json:"caData" yaml:"caData" description:"CA证书"`
+	// CAFiles PEM 格式的 CA 证书文件
+	CAFiles []string `json:"caFiles" yaml:"caFiles" description:"CA证书文件"`
+	// IncludeSystemCAs 配置了 CA 证书时是否同时信任系统 CA
+	IncludeSystemCAs bool `json:"includeSystemCAs" yaml:"includeSystemCAs" description:"是否信任系统CA"`
+	// CertData/KeyData 或 CertFile/KeyFile 用于 mTLS 客户端证书
+	CertData string `json:"certData" yaml:"certData" description:"客户端证书"`
+	KeyData  string `json:"keyData" yaml:"keyData" description:"客户端私钥"`
+	CertFile string `json:"certFile" yaml:"certFile" description:"客户端证书文件"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile" description:"客户端私钥文件"`
+	// ServerName 覆盖 TLS 校验的服务端名称
+	ServerName string `json:"serverName" yaml:"serverName" description:"TLS服务端名称"`
+	// InsecureSkipVerify 不校验服务端证书，仅用于开发环境
+	InsecureSkipVerify bool `json:"insecureSkipVerify" yaml:"insecureSkipVerify" description:"是否跳过证书校验"`
+	// BearerToken 不为空时每个请求添加 Authorization: Bearer 请求头
+	BearerToken string `json:"-" yaml:"-"`
+	// HTTP2 是否尝试使用 HTTP/2
+	HTTP2 bool `json:"http2" yaml:"http2" description:"是否启用HTTP2"`
+	// ProxyURL 代理地址，为空时使用环境变量 HTTP_PROXY/HTTPS_PROXY/NO_PROXY
+	ProxyURL string `json:"proxyUrl" yaml:"proxyUrl" description:"代理地址"`
+	// DisableProxy 不使用任何代理
+	DisableProxy bool `json:"disableProxy" yaml:"disableProxy" description:"是否禁用代理"`
+	// Timeout 单个请求总超时时间，0 表示不超时
+	Timeout               time.Duration `json:"timeout" yaml:"timeout" description:"请求超时时间"`
+	DialTimeout           time.Duration `json:"dialTimeout" yaml:"dialTimeout" description:"建立连接超时时间"`
+	KeepAlive             time.Duration `json:"keepAlive" yaml:"keepAlive" description:"TCP KeepAlive"`
+	TLSHandshakeTimeout   time.Duration `json:"tlsHandshakeTimeout" yaml:"tlsHandshakeTimeout" description:"TLS握手超时时间"`
+	ResponseHeaderTimeout time.Duration `json:"responseHeaderTimeout" yaml:"responseHeaderTimeout" description:"等待响应头超时时间"`
+	IdleConnTimeout       time.Duration `json:"idleConnTimeout" yaml:"idleConnTimeout" description:"空闲连接超时时间"`
+	MaxIdleConns          int           `json:"maxIdleConns" yaml:"maxIdleConns" description:"最大空闲连接数"`
+	MaxIdleConnsPerHost   int           `json:"maxIdleConnsPerHost" yaml:"maxIdleConnsPerHost" description:"每个主机最大空闲连接数"`
+	MaxConnsPerHost       int           `json:"maxConnsPerHost" yaml:"maxConnsPerHost" description:"每个主机最大连接数，0 表示不限制"`
+	// CircuitBreaker 不为空时按主机熔断
+	CircuitBreaker *CircuitBreakerOptions `json:"circuitBreaker" yaml:"circuitBreaker" description:"熔断器"`
+	// Logging 不为空时记录出站请求日志
+	Logging *HTTPLoggingOptions `json:"logging" yaml:"logging" description:"出站请求日志"`
+}
+
+// DefaultHTTPClientOptions 默认选项，参考 http.DefaultTransport
+func DefaultHTTPClientOptions() HTTPClientOptions {
+	return HTTPClientOptions{
+		HTTP2:               true,
+		Timeout:             30 * time.Second,
+		DialTimeout:         30 * time.Second,
+		KeepAlive:           30 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+	}
+}
+
+// WithClusterAuthConfig 使用集群认证信息中的 CA、客户端证书及 Token
+func (o HTTPClientOptions) WithClusterAuthConfig(config datatypes.ClusterAuthConfig) HTTPClientOptions {
+	if len(config.CaData) > 0 {
+		o.CAData = append(o.CAData, config.CaData)
+	}
+	if len(config.CertData) > 0 && len(config.KeyData) > 0 {
+		o.CertData = config.CertData
+		o.KeyData = config.KeyData
+	}
+	if len(config.Token) > 0 {
+		o.BearerToken = config.Token
+	}
+	return o
+}
+
+// pemData 兼容 kubeconfig 中 base64 编码的 PEM 数据
+func pemData(data string) []byte {
+	if strings.Contains(data, "-----BEGIN") {
+		return []byte(data)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data)); err == nil {
+		return decoded
+	}
+	return []byte(data)
+}
+
+// TLSConfig 根据选项生成 TLS 配置
+func (o HTTPClientOptions) TLSConfig() (config *tls.Config, err error) {
+	config = &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if len(o.CAData) > 0 || len(o.CAFiles) > 0 {
+		pool := x509.NewCertPool()
+		if o.IncludeSystemCAs {
+			if pool, err = x509.SystemCertPool(); err != nil {
+				return nil, fmt.Errorf("load system ca failed, err: %s", err.Error())
+			}
+		}
+		for i, data := range o.CAData {
+			if !pool.AppendCertsFromPEM(pemData(data)) {
+				return nil, fmt.Errorf("ca data at index %d contains no valid certificate", i)
+			}
+		}
+		for _, file := range o.CAFiles {
+			data, er := os.ReadFile(file)
+			if er != nil {
+				return nil, fmt.Errorf("read ca file: %s failed, err: %s", file, er.Error())
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("ca file: %s contains no valid certificate", file)
+			}
+		}
+		config.RootCAs = pool
+	}
+	switch {
+	case len(o.CertData) > 0 || len(o.KeyData) > 0:
+		cert, er := tls.X509KeyPair(pemData(o.CertData), pemData(o.KeyData))
+		if er != nil {
+			return nil, fmt.Errorf("load client certificate failed, err: %s", er.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	case len(o.CertFile) > 0 || len(o.KeyFile) > 0:
+		cert, er := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if er != nil {
+			return nil, fmt.Errorf("load client certificate file failed, err: %s", er.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// NewHTTPTransport 根据选项创建 http.Transport
+func NewHTTPTransport(options HTTPClientOptions) (transport *http.Transport, err error) {
+	tlsConfig, err := options.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport = &http.Transport{
+		TLSClientConfig: tlsConfig,
+		DialContext: (&net.Dialer{
+			Timeout:   options.DialTimeout,
+			KeepAlive: options.KeepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:     options.HTTP2,
+		TLSHandshakeTimeout:   options.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: options.ResponseHeaderTimeout,
+		IdleConnTimeout:       options.IdleConnTimeout,
+		MaxIdleConns:          options.MaxIdleConns,
+		MaxIdleConnsPerHost:   options.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       options.MaxConnsPerHost,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if !options.HTTP2 {
+		// 非 nil 的空 map 会禁用 HTTP/2
+		transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
+	}
+	switch {
+	case options.DisableProxy:
+		transport.Proxy = nil
+	case len(options.ProxyURL) > 0:
+		proxy, er := url.Parse(options.ProxyURL)
+		if er != nil {
+			return nil, fmt.Errorf("parse proxy url: %s failed, err: %s", options.ProxyURL, er.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	default:
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+	return transport, nil
+}
+
+// NewHTTPClient 根据选项创建 HTTP 客户端，请求上下文中有请求 ID 时会添加 X-Request-ID 请求头
+//
+//	client, err := common.NewHTTPClient(common.DefaultHTTPClientOptions().WithClusterAuthConfig(cluster.AuthConfig))
+func NewHTTPClient(options HTTPClientOptions) (client *http.Client, err error) {
+	transport, err := NewHTTPTransport(options)
+	if err != nil {
+		return nil, err
+	}
+	client = &http.Client{Transport: &requestIDTransport{next: transport}, Timeout: options.Timeout}
+	if options.Logging != nil {
+		client.Transport = NewLoggingTransport(client.Transport, *options.Logging)
+	}
+	if options.CircuitBreaker != nil {
+		client.Transport = NewCircuitBreakerTransport(client.Transport, *options.CircuitBreaker)
+	}
+	if len(options.BearerToken) > 0 {
+		client.Transport = &bearerTokenTransport{token: options.BearerToken, next: client.Transport}
+	}
+	return client, nil
+}
+
+type bearerTokenTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *bearerTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("Authorization")) > 0 {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(req)
+}
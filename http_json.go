@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// MaxRemoteErrorBodySize 非 2xx 响应读取的最大字节数
+const MaxRemoteErrorBodySize = 1 << 20
+
+// RemoteError 远程服务返回非 2xx 响应时的错误，Response 为解析出的 ResponseError，非本系统服务时为空
+type RemoteError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+	Response   *ResponseError
+}
+
+func (e *RemoteError) Error() string {
+	if e.Response != nil {
+		if len(e.Response.Detail) > 0 {
+			return fmt.Sprintf("%s %s response status: %d, message: %s, detail: %s", e.Method, e.URL, e.StatusCode, e.Response.Message, e.Response.Detail)
+		}
+		return fmt.Sprintf("%s %s response status: %d, message: %s", e.Method, e.URL, e.StatusCode, e.Response.Message)
+	}
+	return fmt.Sprintf("%s %s response status: %d, body: %s", e.Method, e.URL, e.StatusCode, string(e.Body))
+}
+
+// ErrorData 转换为 ErrorData，用于将远程服务的错误继续返回给调用方
+func (e *RemoteError) ErrorData(lang string) ErrorData {
+	data := ErrorData{Lang: lang, ResponseCode: e.StatusCode, Err: e, MsgCode: DefaultErrorMsgCode}
+	if e.Response != nil && len(e.Response.Message) > 0 {
+		data.MsgCode = e.Response.Message
+	}
+	return data
+}
+
+// parseRemoteError 兼容 ResponseError 及 application/problem+json 响应
+func parseRemoteError(body []byte) *ResponseError {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return nil
+	}
+	if _, exist := fields["status"]; exist {
+		var problem ProblemDetails
+		if json.Unmarshal(body, &problem) == nil && len(problem.MsgCode) > 0 {
+			return &ResponseError{Message: problem.MsgCode, Detail: problem.Detail, Alert: problem.Alert, RequestURI: problem.Instance, ErrorID: problem.ErrorID, RequestID: problem.RequestID, Fields: problem.Fields}
+		}
+		return nil
+	}
+	var responseError ResponseError
+	if json.Unmarshal(body, &responseError) != nil || (len(responseError.Message) == 0 && len(responseError.Alert) == 0) {
+		return nil
+	}
+	return &responseError
+}
+
+// DoJSON 请求体编码为 JSON，2xx 响应解析为 T，非 2xx 响应返回 *RemoteError，响应体总是会被关闭
+// client 为空时使用 DefaultHTTPClient
+//
+//	version, err := common.DoJSON[common.K8sVersion](ctx, client, http.MethodGet, address, nil, nil, nil, common.DefaultRetryPolicy())
+func DoJSON[T any](ctx context.Context, client *http.Client, method, address string, headers map[string]string, queries map[string]interface{}, body interface{}, policy RetryPolicy) (result T, err error) {
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	if _, exist := headers["Accept"]; !exist {
+		headers["Accept"] = "application/json"
+	}
+	response, err := RequestWithContext(ctx, client, method, address, headers, queries, body, policy)
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, MaxRemoteErrorBodySize))
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		data, _ := io.ReadAll(io.LimitReader(response.Body, MaxRemoteErrorBodySize))
+		remoteErr := &RemoteError{Method: method, URL: response.Request.URL.Redacted(), StatusCode: response.StatusCode, Body: data}
+		if strings.Contains(response.Header.Get("Content-Type"), "json") {
+			remoteErr.Response = parseRemoteError(data)
+		}
+		return result, remoteErr
+	}
+	if response.StatusCode == http.StatusNoContent || method == http.MethodHead {
+		return result, nil
+	}
+	if err = json.NewDecoder(response.Body).Decode(&result); err != nil && err != io.EOF {
+		return result, fmt.Errorf("decode response of %s %s failed, err: %s", method, response.Request.URL.Redacted(), err.Error())
+	}
+	return result, nil
+}
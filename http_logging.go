@@ -0,0 +1,279 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedactedValue 敏感信息脱敏后的值
+const RedactedValue = "******"
+
+// DefaultSensitiveHeaders 默认脱敏的请求头及响应头
+var DefaultSensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// DefaultSensitiveParams 默认脱敏的查询参数、表单及 JSON 字段
+var DefaultSensitiveParams = []string{"access_token", "refresh_token", "id_token", "client_secret", "password"}
+
+// HTTPLoggingOptions 出站请求日志选项
+type HTTPLoggingOptions struct {
+	// Logger 为空时使用 zap.S()
+	Logger *zap.SugaredLogger `json:"-" yaml:"-"`
+	// LogHeaders 是否记录请求头及响应头
+	LogHeaders bool `json:"logHeaders" yaml:"logHeaders" description:"是否记录请求头及响应头"`
+	// LogBody 是否记录请求体及响应体，请求体需可通过 req.GetBody 重新获取
+	LogBody bool `json:"logBody" yaml:"logBody" description:"是否记录请求体及响应体"`
+	// MaxBodySize 记录的请求体及响应体最大字节数，默认 4096
+	MaxBodySize int `json:"maxBodySize" yaml:"maxBodySize" description:"记录的最大字节数"`
+	// SensitiveHeaders 在 DefaultSensitiveHeaders 之外需要脱敏的头
+	SensitiveHeaders []string `json:"sensitiveHeaders" yaml:"sensitiveHeaders" description:"需要脱敏的头"`
+	// SensitiveParams 在 DefaultSensitiveParams 之外需要脱敏的参数
+	SensitiveParams []string `json:"sensitiveParams" yaml:"sensitiveParams" description:"需要脱敏的参数"`
+}
+
+// LoggingTransport 记录出站请求的 http.RoundTripper，敏感信息会被脱敏
+type LoggingTransport struct {
+	options  HTTPLoggingOptions
+	next     http.RoundTripper
+	headers  map[string]bool
+	params   map[string]bool
+	redactor *bodyRedactor
+}
+
+// NewLoggingTransport 创建出站请求日志，next 为空时使用 http.DefaultTransport
+//
+//	client.Transport = common.NewLoggingTransport(client.Transport, common.HTTPLoggingOptions{Logger: logger, LogBody: true})
+func NewLoggingTransport(next http.RoundTripper, options HTTPLoggingOptions) *LoggingTransport {
+	if options.Logger == nil {
+		options.Logger = zap.S()
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = 4096
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	t := &LoggingTransport{options: options, next: next, headers: make(map[string]bool), params: make(map[string]bool)}
+	for _, header := range append(append([]string{}, DefaultSensitiveHeaders...), options.SensitiveHeaders...) {
+		t.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	params := append(append([]string{}, DefaultSensitiveParams...), options.SensitiveParams...)
+	for _, param := range params {
+		t.params[strings.ToLower(param)] = true
+	}
+	t.redactor = newBodyRedactor(params)
+	return t
+}
+
+func (t *LoggingTransport) redactHeader(header http.Header) map[string]string {
+	values := make(map[string]string, len(header))
+	for name, value := range header {
+		if t.headers[http.CanonicalHeaderKey(name)] {
+			values[name] = RedactedValue
+		} else {
+			values[name] = strings.Join(value, ", ")
+		}
+	}
+	return values
+}
+
+func (t *LoggingTransport) redactURL(req *http.Request) string {
+	u := *req.URL
+	if len(u.RawQuery) > 0 {
+		query := u.Query()
+		for name := range query {
+			if t.params[strings.ToLower(name)] {
+				query.Set(name, RedactedValue)
+			}
+		}
+		u.RawQuery = strings.ReplaceAll(query.Encode(), url.QueryEscape(RedactedValue), RedactedValue)
+	}
+	return u.Redacted()
+}
+
+// bodyRedactor 对请求体及响应体脱敏，JSON 中敏感字段的值无论类型均替换为 RedactedValue，表单中替换 key=value 的值
+type bodyRedactor struct {
+	names       map[string]bool
+	formPattern *regexp.Regexp
+}
+
+func newBodyRedactor(params []string) *bodyRedactor {
+	r := &bodyRedactor{names: make(map[string]bool)}
+	var names []string
+	for _, param := range params {
+		r.names[strings.ToLower(param)] = true
+		names = append(names, regexp.QuoteMeta(param))
+	}
+	r.formPattern = regexp.MustCompile(`(?i)((?:^|[&?])(?:` + strings.Join(names, "|") + `)=)[^&\s]*`)
+	return r
+}
+
+// redact truncated 为 true 时 body 可能不是完整的 JSON，被截断的敏感值同样会被替换，并添加截断标记
+func (r *bodyRedactor) redact(body []byte, truncated bool) string {
+	value := r.formPattern.ReplaceAllString(r.redactJSON(string(body)), "${1}"+RedactedValue)
+	if truncated {
+		value += "...(truncated)"
+	}
+	return value
+}
+
+// redactJSON 逐个扫描字符串，字符串后紧跟冒号时视为字段名，敏感字段的整个值被替换
+func (r *bodyRedactor) redactJSON(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); {
+		if s[i] != '"' {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		end := scanJSONString(s, i)
+		key := s[i:end]
+		b.WriteString(key)
+		i = end
+		colon := skipJSONSpace(s, i)
+		if colon >= len(s) || s[colon] != ':' || !r.sensitive(key) {
+			continue
+		}
+		start := skipJSONSpace(s, colon+1)
+		b.WriteString(s[i:start])
+		b.WriteString(`"` + RedactedValue + `"`)
+		i = skipJSONValue(s, start)
+	}
+	return b.String()
+}
+
+func (r *bodyRedactor) sensitive(quoted string) bool {
+	name, err := strconv.Unquote(quoted)
+	if err != nil {
+		name = strings.Trim(quoted, `"`)
+	}
+	return r.names[strings.ToLower(name)]
+}
+
+func skipJSONSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// scanJSONString i 为起始引号的位置，返回结束引号之后的位置，未结束时返回 len(s)
+func scanJSONString(s string, i int) int {
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// skipJSONValue 返回从 i 开始的 JSON 值之后的位置，对象及数组按括号配对跳过
+func skipJSONValue(s string, i int) int {
+	if i >= len(s) {
+		return i
+	}
+	switch s[i] {
+	case '"':
+		return scanJSONString(s, i)
+	case '{', '[':
+		depth := 0
+		for i < len(s) {
+			switch s[i] {
+			case '"':
+				i = scanJSONString(s, i)
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+			i++
+		}
+		return i
+	default:
+		for i < len(s) && !strings.ContainsRune(",}] \t\n\r", rune(s[i])) {
+			i++
+		}
+		return i
+	}
+}
+
+// peek 读取最多 MaxBodySize 字节，返回可继续完整读取的 body
+func (t *LoggingTransport) peek(body io.ReadCloser) (data []byte, truncated bool, rest io.ReadCloser) {
+	buf := make([]byte, t.options.MaxBodySize+1)
+	n, _ := io.ReadFull(body, buf)
+	data = buf[:n]
+	rest = struct {
+		io.Reader
+		io.Closer
+	}{Reader: io.MultiReader(bytes.NewReader(data), body), Closer: body}
+	if n > t.options.MaxBodySize {
+		return data[:t.options.MaxBodySize], true, rest
+	}
+	return data, false, rest
+}
+
+func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fields := []interface{}{"method", req.Method, "url", t.redactURL(req)}
+	if t.options.LogHeaders {
+		fields = append(fields, "requestHeaders", t.redactHeader(req.Header))
+	}
+	if t.options.LogBody && req.Body != nil && req.Body != http.NoBody && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, truncated, _ := t.peek(body)
+			_ = body.Close()
+			fields = append(fields, "requestBody", t.redactor.redact(data, truncated))
+		}
+	}
+	start := time.Now()
+	response, err := t.next.RoundTrip(req)
+	fields = append(fields, "latency", time.Since(start).String())
+	if err != nil {
+		fields = append(fields, "err", err.Error())
+		t.options.Logger.Errorw("outbound http request failed", fields...)
+		return response, err
+	}
+	fields = append(fields, "status", response.StatusCode)
+	if t.options.LogHeaders {
+		fields = append(fields, "responseHeaders", t.redactHeader(response.Header))
+	}
+	if t.options.LogBody && response.Body != nil && response.Body != http.NoBody {
+		var (
+			data      []byte
+			truncated bool
+		)
+		data, truncated, response.Body = t.peek(response.Body)
+		fields = append(fields, "responseBody", t.redactor.redact(data, truncated))
+	}
+	t.options.Logger.Debugw("outbound http request", fields...)
+	return response, nil
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import "testing"
+
+func TestBodyRedactor(t *testing.T) {
+	redactor := newBodyRedactor(append(append([]string{}, DefaultSensitiveParams...), "pin"))
+	cases := []struct {
+		name      string
+		body      string
+		truncated bool
+		expect    string
+	}{
+		{"string", `{"username":"admin","password":"secret"}`, false, `{"username":"admin","password":"******"}`},
+		{"escaped string", `{"password":"se\"cr\\et","name":"a"}`, false, `{"password":"******","name":"a"}`},
+		{"number", `{"password":123456}`, false, `{"password":"******"}`},
+		{"negative float", `{"pin": -12.5e3, "id":1}`, false, `{"pin": "******", "id":1}`},
+		{"literal", `{"password":true,"client_secret":null}`, false, `{"password":"******","client_secret":"******"}`},
+		{"object", `{"password":{"old":"a","new":{"v":"}"}},"id":1}`, false, `{"password":"******","id":1}`},
+		{"array", `{"refresh_token":["a",["b"],{"c":1}],"id":1}`, false, `{"refresh_token":"******","id":1}`},
+		{"nested key", `{"user":{"name":"a","Password":"b"},"list":[{"access_token":"c"}]}`, false, `{"user":{"name":"a","Password":"******"},"list":[{"access_token":"******"}]}`},
+		{"whitespace", "{\n  \"password\" :\n  \"secret\"\n}", false, "{\n  \"password\" :\n  \"******\"\n}"},
+		{"unicode escaped key", `{"pass\u0077ord":"secret"}`, false, `{"pass\u0077ord":"******"}`},
+		{"sensitive value only", `{"name":"password","type":"pin"}`, false, `{"name":"password","type":"pin"}`},
+		{"truncated string", `{"id":1,"password":"sec`, true, `{"id":1,"password":"******"...(truncated)`},
+		{"truncated object", `{"id":1,"password":{"a":[1,2`, true, `{"id":1,"password":"******"...(truncated)`},
+		{"form", `username=admin&password=secret&client_secret=x`, false, `username=admin&password=******&client_secret=******`},
+		{"plain text", `password is secret`, false, `password is secret`},
+	}
+	for _, item := range cases {
+		if value := redactor.redact([]byte(item.body), item.truncated); value != item.expect {
+			t.Errorf("%s: redact: %s, expect: %s", item.name, value, item.expect)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"k8s.io/klog/v2"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultMaxRetryAfter MaxRetryAfter 及 MaxBackoff 均未设置时 Retry-After 的最大等待时间
+const DefaultMaxRetryAfter = 30 * time.Second
+
+// RetryPolicy 重试策略，只对幂等方法或携带 Idempotency-Key 请求头的请求重试
+type RetryPolicy struct {
+	// MaxAttempts 最大请求次数，包含第一次请求，小于等于 1 时不重试
+	MaxAttempts int `json:"maxAttempts" yaml:"maxAttempts" description:"最大请求次数"`
+	// InitialBackoff 第一次重试前的等待时间
+	InitialBackoff time.Duration `json:"initialBackoff" yaml:"initialBackoff" description:"初始等待时间"`
+	// MaxBackoff 单次等待的最大时间
+	MaxBackoff time.Duration `json:"maxBackoff" yaml:"maxBackoff" description:"最大等待时间"`
+	// MaxRetryAfter 响应头 Retry-After 允许的最大等待时间，超过时不再重试并返回该响应，为空时使用 MaxBackoff
+	MaxRetryAfter time.Duration `json:"maxRetryAfter" yaml:"maxRetryAfter" description:"Retry-After最大等待时间"`
+	// Multiplier 每次重试等待时间的倍数
+	Multiplier float64 `json:"multiplier" yaml:"multiplier" description:"等待时间倍数"`
+	// Jitter 等待时间的随机抖动比例，取值 0-1
+	Jitter float64 `json:"jitter" yaml:"jitter" description:"随机抖动比例"`
+	// RetryStatus 需要重试的响应码
+	RetryStatus []int `json:"retryStatus" yaml:"retryStatus" description:"需要重试的响应码"`
+}
+
+// DefaultRetryPolicy 默认最多请求 3 次，等待时间 200ms 起指数增长
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		MaxRetryAfter:  DefaultMaxRetryAfter,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryStatus:    []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	}
+}
+
+// NoRetryPolicy 不重试
+func NoRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(math.Max(p.Multiplier, 1), float64(attempt))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(backoff)
+}
+
+func (p RetryPolicy) maxRetryAfter() time.Duration {
+	if p.MaxRetryAfter > 0 {
+		return p.MaxRetryAfter
+	}
+	if p.MaxBackoff > 0 {
+		return p.MaxBackoff
+	}
+	return DefaultMaxRetryAfter
+}
+
+func (p RetryPolicy) retryStatus(status int) bool {
+	return IntInArray(status, p.RetryStatus)
+}
+
+// requestRetryable GET/HEAD/OPTIONS/TRACE/PUT/DELETE 或携带 Idempotency-Key 的请求可以重试
+func requestRetryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return len(req.Header.Get(IdempotencyKeyHeader)) > 0
+}
+
+// retryAfter 解析 Retry-After 响应头，支持秒数及 HTTP 日期
+func retryAfter(resp *http.Response) (wait time.Duration, ok bool) {
+	value := resp.Header.Get("Retry-After")
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait = time.Until(date); wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// DoWithRetry 按重试策略发送请求，请求体需可通过 req.GetBody 重新获取，超过 ctx 的截止时间或熔断器打开时不再重试
+func DoWithRetry(client *http.Client, req *http.Request, policy RetryPolicy) (response *http.Response, err error) {
+	ctx := req.Context()
+	retryable := policy.MaxAttempts > 1 && requestRetryable(req) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, er := req.GetBody()
+			if er != nil {
+				return nil, er
+			}
+			req.Body = body
+		}
+		response, err = client.Do(req)
+		if !retryable || attempt+1 >= policy.MaxAttempts || ctx.Err() != nil || errors.Is(err, ErrCircuitOpen) {
+			return response, err
+		}
+		if err == nil && !policy.retryStatus(response.StatusCode) {
+			return response, nil
+		}
+		wait := policy.backoff(attempt)
+		if err == nil {
+			if after, ok := retryAfter(response); ok {
+				// 服务端要求等待的时间过长时直接返回，避免无截止时间的 ctx 长时间阻塞
+				if after > policy.maxRetryAfter() {
+					return response, nil
+				}
+				wait = after
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return response, err
+		}
+		if err == nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+			_ = response.Body.Close()
+			klog.V(4).Infof("retry request, method: %s, address: %s, status: %d, attempt: %d, wait: %s", req.Method, req.URL.Redacted(), response.StatusCode, attempt+1, wait)
+		} else {
+			klog.V(4).Infof("retry request, method: %s, address: %s, err: %s, attempt: %d, wait: %s", req.Method, req.URL.Redacted(), err.Error(), attempt+1, wait)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if err == nil {
+				err = ctx.Err()
+			}
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// HttpRequestWithContext 与 HttpRequest 相同，请求随 ctx 取消，并按重试策略重试，传递 ctx 中的请求 ID
+func HttpRequestWithContext(ctx context.Context, client *http.Client, method, address string, headers, cookies map[string]interface{}, queries url.Values, body []byte, policy RetryPolicy) (response *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, address, bytes.NewReader(body))
+	if err != nil {
+		err = fmt.Errorf("create http request failed, method: %s, address: %s, err: %s", method, address, err.Error())
+		klog.Error(err)
+		return response, err
+	}
+	for k, v := range headers {
+		req.Header.Add(k, fmt.Sprintf("%s", v))
+	}
+	for k, v := range cookies {
+		req.AddCookie(&http.Cookie{Name: k, Value: fmt.Sprintf("%s", v)})
+	}
+	if len(queries) > 0 {
+		queryValues := req.URL.Query()
+		for k, values := range queries {
+			for _, v := range values {
+				queryValues.Add(k, v)
+			}
+		}
+		req.URL.RawQuery = queryValues.Encode()
+	}
+	setRequestIDHeader(req)
+	return DoWithRetry(client, req, policy)
+}
+
+var (
+	defaultHTTPClient     *http.Client
+	defaultHTTPClientOnce sync.Once
+)
+
+// DefaultHTTPClient 使用 DefaultHTTPClientOptions 创建的共享客户端
+func DefaultHTTPClient() *http.Client {
+	defaultHTTPClientOnce.Do(func() {
+		client, err := NewHTTPClient(DefaultHTTPClientOptions())
+		if err != nil {
+			klog.Errorf("create default http client failed, err: %s", err.Error())
+			client = &http.Client{Timeout: DefaultHTTPClientOptions().Timeout}
+		}
+		defaultHTTPClient = client
+	})
+	return defaultHTTPClient
+}
+
+// RequestWithContext 与 Request 相同，请求体编码为 JSON，请求随 ctx 取消，并按重试策略重试，传递 ctx 中的请求 ID
+// client 为空时使用 DefaultHTTPClient
+func RequestWithContext(ctx context.Context, client *http.Client, method, address string, headers map[string]string, queries map[string]interface{}, body interface{}, policy RetryPolicy) (response *http.Response, err error) {
+	if client == nil {
+		client = DefaultHTTPClient()
+	}
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, er := json.Marshal(body)
+		if er != nil {
+			return nil, er
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, address, reader)
+	if err != nil {
+		klog.Errorf("create request failed, err: %s", err.Error())
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if len(queries) > 0 {
+		queryValues := req.URL.Query()
+		for k, v := range queries {
+			queryValues.Add(k, fmt.Sprintf("%v", v))
+		}
+		req.URL.RawQuery = queryValues.Encode()
+	}
+	setRequestIDHeader(req)
+	return DoWithRetry(client, req, policy)
+}
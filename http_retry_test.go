@@ -0,0 +1,207 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// retryServer 依次返回 statuses 中的响应码，超出后返回 200，并记录每次收到的请求体及查询参数
+type retryServer struct {
+	mu         sync.Mutex
+	statuses   []int
+	retryAfter string
+	bodies     []string
+	queries    []string
+}
+
+func (s *retryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := http.StatusOK
+	if attempt := len(s.bodies); attempt < len(s.statuses) {
+		status = s.statuses[attempt]
+	}
+	s.bodies = append(s.bodies, string(body))
+	s.queries = append(s.queries, r.URL.RawQuery)
+	if len(s.retryAfter) > 0 && status != http.StatusOK {
+		w.Header().Set("Retry-After", s.retryAfter)
+	}
+	w.WriteHeader(status)
+}
+
+func (s *retryServer) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+func testRetryPolicy() RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = 5 * time.Millisecond
+	policy.Jitter = 0
+	return policy
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	for attempt, expect := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		if backoff := policy.backoff(attempt); backoff != expect {
+			t.Fatalf("attempt: %d, backoff: %s, expect: %s", attempt, backoff, expect)
+		}
+	}
+	policy.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if backoff := policy.backoff(1); backoff < 160*time.Millisecond || backoff > 240*time.Millisecond {
+			t.Fatalf("backoff: %s out of jitter range", backoff)
+		}
+	}
+	if wait := (RetryPolicy{MaxBackoff: 3 * time.Second}).maxRetryAfter(); wait != 3*time.Second {
+		t.Fatalf("max retry after: %s, expect MaxBackoff", wait)
+	}
+	if wait := (RetryPolicy{}).maxRetryAfter(); wait != DefaultMaxRetryAfter {
+		t.Fatalf("max retry after: %s, expect DefaultMaxRetryAfter", wait)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	cases := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"3", 3 * time.Second, 3 * time.Second},
+		{"0", 0, 0},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 59 * time.Minute, time.Hour},
+	}
+	for _, item := range cases {
+		wait, ok := retryAfter(&http.Response{Header: http.Header{"Retry-After": {item.value}}})
+		if !ok || wait < item.min || wait > item.max {
+			t.Fatalf("value: %s, wait: %s, ok: %t", item.value, wait, ok)
+		}
+	}
+	for _, value := range []string{"", "-1", "soon"} {
+		if _, ok := retryAfter(&http.Response{Header: http.Header{"Retry-After": {value}}}); ok {
+			t.Fatalf("value: %q should be ignored", value)
+		}
+	}
+}
+
+func TestDoWithRetryReplaysBody(t *testing.T) {
+	backend := &retryServer{statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway}}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	response, err := HttpRequestWithContext(context.Background(), server.Client(), http.MethodPost, server.URL+"?a=1",
+		map[string]interface{}{IdempotencyKeyHeader: "key"}, nil, url.Values{"b": {"2"}}, []byte(`{"name":"admin"}`), testRetryPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK || backend.attempts() != 3 {
+		t.Fatalf("status: %d, attempts: %d", response.StatusCode, backend.attempts())
+	}
+	for i, body := range backend.bodies {
+		if body != `{"name":"admin"}` || backend.queries[i] != "a=1&b=2" {
+			t.Fatalf("attempt: %d, body: %s, query: %s", i, body, backend.queries[i])
+		}
+	}
+}
+
+func TestDoWithRetryNotRetryable(t *testing.T) {
+	cases := []struct {
+		name    string
+		method  string
+		headers map[string]interface{}
+		status  int
+		policy  RetryPolicy
+	}{
+		{"post without idempotency key", http.MethodPost, nil, http.StatusServiceUnavailable, testRetryPolicy()},
+		{"status not in RetryStatus", http.MethodGet, nil, http.StatusInternalServerError, testRetryPolicy()},
+		{"no retry policy", http.MethodGet, nil, http.StatusServiceUnavailable, NoRetryPolicy()},
+	}
+	for _, item := range cases {
+		backend := &retryServer{statuses: []int{item.status, item.status, item.status}}
+		server := httptest.NewServer(backend)
+		response, err := HttpRequestWithContext(context.Background(), server.Client(), item.method, server.URL, item.headers, nil, nil, []byte("{}"), item.policy)
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", item.name, err)
+		}
+		_ = response.Body.Close()
+		if response.StatusCode != item.status || backend.attempts() != 1 {
+			t.Fatalf("%s: status: %d, attempts: %d", item.name, response.StatusCode, backend.attempts())
+		}
+	}
+}
+
+func TestDoWithRetryRetryAfter(t *testing.T) {
+	backend := &retryServer{statuses: []int{http.StatusTooManyRequests}, retryAfter: "1"}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+	policy := testRetryPolicy()
+	policy.MaxRetryAfter = 2 * time.Second
+
+	start := time.Now()
+	response, err := HttpRequestWithContext(context.Background(), server.Client(), http.MethodGet, server.URL, nil, nil, nil, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK || backend.attempts() != 2 || time.Since(start) < time.Second {
+		t.Fatalf("status: %d, attempts: %d, elapsed: %s, should wait Retry-After", response.StatusCode, backend.attempts(), time.Since(start))
+	}
+
+	// Retry-After 超过 MaxRetryAfter 时直接返回响应
+	backend = &retryServer{statuses: []int{http.StatusServiceUnavailable}, retryAfter: strconv.Itoa(3600)}
+	server2 := httptest.NewServer(backend)
+	defer server2.Close()
+	response, err = HttpRequestWithContext(context.Background(), server2.Client(), http.MethodGet, server2.URL, nil, nil, nil, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusServiceUnavailable || backend.attempts() != 1 {
+		t.Fatalf("status: %d, attempts: %d, should not wait Retry-After", response.StatusCode, backend.attempts())
+	}
+
+	// 等待时间超过 ctx 截止时间时直接返回响应
+	backend = &retryServer{statuses: []int{http.StatusServiceUnavailable}, retryAfter: "1"}
+	server3 := httptest.NewServer(backend)
+	defer server3.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	response, err = HttpRequestWithContext(ctx, server3.Client(), http.MethodGet, server3.URL, nil, nil, nil, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusServiceUnavailable || backend.attempts() != 1 {
+		t.Fatalf("status: %d, attempts: %d, should not wait past the deadline", response.StatusCode, backend.attempts())
+	}
+}
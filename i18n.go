@@ -35,19 +35,16 @@ import (
 )
 
 func GetLanguageFromCtx(ctx context.Context, reqAttributeKey string) (lang string) {
-	lan := ctx.Value(reqAttributeKey)
-	if lan != nil {
-		return lan.(string)
+	if lan, ok := ctx.Value(reqAttributeKey).(string); ok && len(lan) > 0 {
+		return lan
 	}
 	return I18nZH
 }
 func GetLanguageFromReq(req *restful.Request, reqAttributeKey string) (lang string) {
-	langAttr := req.Attribute(reqAttributeKey)
-	lang = I18nZH
-	if langAttr != nil {
-		lang = langAttr.(string)
+	if lan, ok := req.Attribute(reqAttributeKey).(string); ok && len(lan) > 0 {
+		return lan
 	}
-	return lang
+	return I18nZH
 }
 func I18nInit(i18nFiles embed.FS, logger *zap.SugaredLogger) (bundle *i18n.Bundle, universalTranslator *ut.UniversalTranslator) {
 	// todo add other language
@@ -62,9 +59,16 @@ func I18nInit(i18nFiles embed.FS, logger *zap.SugaredLogger) (bundle *i18n.Bundl
 	if err != nil {
 		logger.Fatalf("load i18n message file failed, err: %s", err.Error())
 	}
+	if err = AddDefaultMessages(bundle); err != nil {
+		logger.Fatalf("add default i18n messages failed, err: %s", err.Error())
+	}
 	return
 }
 func GetLocaleMessage(bundle *i18n.Bundle, templateData map[string]interface{}, lang string, id string) (msg string, err error) {
+	// bundle 为空时直接返回信息编码，避免在异常恢复等路径中再次 panic
+	if bundle == nil {
+		return id, nil
+	}
 	localizer := i18n.NewLocalizer(bundle, lang)
 	msg, err = localizer.Localize(&i18n.LocalizeConfig{DefaultMessage: &i18n.Message{ID: id}, TemplateData: templateData})
 	if len(msg) == 0 {
@@ -88,13 +92,12 @@ func ValidateTrans(unTrans *ut.UniversalTranslator, validate *validator.Validate
 }
 
 func ValidateTransCtx(ctx context.Context, unTrans *ut.UniversalTranslator, ctxLangKey string, validate *validator.Validate, err error) FiledValidFailed {
-	lang := ctx.Value(ctxLangKey)
 	lan := I18nZH
-	if lang != nil {
-		lan = lang.(string)
+	if lang, ok := ctx.Value(ctxLangKey).(string); ok && len(lang) > 0 {
+		lan = lang
 	}
 	trans, _ := unTrans.GetTranslator(lan)
-	switch lang {
+	switch lan {
 	case I18nZH:
 		_ = zh_translations.RegisterDefaultTranslations(validate, trans)
 	case I18nEN:
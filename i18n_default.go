@@ -0,0 +1,62 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"gopkg.in/yaml.v2"
+	"path"
+)
+
+// defaultMessages 本库内置错误编码的默认信息
+//
+//go:embed locales/*.yaml
+var defaultMessages embed.FS
+
+// AddDefaultMessages 为 bundle 中已加载的语言补充本库内置错误编码的默认信息，应用已定义的信息不会被覆盖
+// 只补充 en 及 zh，其他语言需要应用自行定义，I18nInit 会自动调用
+func AddDefaultMessages(bundle *i18n.Bundle) error {
+	unmarshalFuncs := map[string]i18n.UnmarshalFunc{"yaml": yaml.Unmarshal}
+	for _, tag := range bundle.LanguageTags() {
+		base, _ := tag.Base()
+		file := path.Join("locales", fmt.Sprintf("%s.yaml", base.String()))
+		data, err := defaultMessages.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		messageFile, err := i18n.ParseMessageFileBytes(data, file, unmarshalFuncs)
+		if err != nil {
+			return fmt.Errorf("parse default message file: %s failed, err: %s", file, err.Error())
+		}
+		localizer := i18n.NewLocalizer(bundle, tag.String())
+		var missing []*i18n.Message
+		for _, message := range messageFile.Messages {
+			_, msgTag, err := localizer.LocalizeWithTag(&i18n.LocalizeConfig{MessageID: message.ID})
+			var notFound *i18n.MessageNotFoundErr
+			if errors.As(err, &notFound) || msgTag != tag {
+				missing = append(missing, message)
+			}
+		}
+		if err = bundle.AddMessages(tag, missing...); err != nil {
+			return fmt.Errorf("add default messages for locale: %s failed, err: %s", tag, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/language"
+	"strings"
+)
+
+const DefaultLanguageAttributeKey = "RequestLanguage"
+
+// LanguageOptions 请求语言协商选项
+type LanguageOptions struct {
+	// AttributeKey 语言在请求属性及请求上下文中的键，默认为 RequestLanguage
+	AttributeKey string
+	// QueryParam 指定语言的查询参数，默认为 lang
+	QueryParam string
+	// Cookie 指定语言的 Cookie，默认为 lang
+	Cookie string
+	// UserLanguage 从 token 等用户信息中获取用户设置的语言，可为空
+	UserLanguage func(req *restful.Request) string
+	// Default 无法协商时使用的语言，默认为 I18nZH
+	Default string
+}
+
+// LanguageNegotiator 根据 bundle 中已加载的语言协商请求语言
+type LanguageNegotiator struct {
+	options LanguageOptions
+	tags    []language.Tag
+	matcher language.Matcher
+}
+
+func NewLanguageNegotiator(bundle *i18n.Bundle, options LanguageOptions) *LanguageNegotiator {
+	if len(options.AttributeKey) == 0 {
+		options.AttributeKey = DefaultLanguageAttributeKey
+	}
+	if len(options.QueryParam) == 0 {
+		options.QueryParam = "lang"
+	}
+	if len(options.Cookie) == 0 {
+		options.Cookie = "lang"
+	}
+	if len(options.Default) == 0 {
+		options.Default = I18nZH
+	}
+	tags := bundle.LanguageTags()
+	return &LanguageNegotiator{options: options, tags: tags, matcher: language.NewMatcher(tags)}
+}
+
+// match 查找与 tags 最匹配的已加载语言
+func (n *LanguageNegotiator) match(tags ...language.Tag) (lang string, ok bool) {
+	if len(tags) == 0 || len(n.tags) == 0 {
+		return "", false
+	}
+	_, index, confidence := n.matcher.Match(tags...)
+	if confidence == language.No {
+		return "", false
+	}
+	return n.tags[index].String(), true
+}
+
+func (n *LanguageNegotiator) matchString(value string) (lang string, ok bool) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return "", false
+	}
+	tag, err := language.Parse(value)
+	if err != nil {
+		return "", false
+	}
+	return n.match(tag)
+}
+
+// Negotiate 按查询参数、Cookie、用户设置、Accept-Language 的顺序协商语言
+func (n *LanguageNegotiator) Negotiate(req *restful.Request) string {
+	if lang, ok := n.matchString(req.QueryParameter(n.options.QueryParam)); ok {
+		return lang
+	}
+	if cookie, err := req.Request.Cookie(n.options.Cookie); err == nil {
+		if lang, ok := n.matchString(cookie.Value); ok {
+			return lang
+		}
+	}
+	if n.options.UserLanguage != nil {
+		if lang, ok := n.matchString(n.options.UserLanguage(req)); ok {
+			return lang
+		}
+	}
+	if tags, _, err := language.ParseAcceptLanguage(req.HeaderParameter("Accept-Language")); err == nil {
+		if lang, ok := n.match(tags...); ok {
+			return lang
+		}
+	}
+	return n.options.Default
+}
+
+// Filter 将协商的语言写入请求属性及请求上下文，可通过 GetLanguageFromReq 及 GetLanguageFromCtx 获取
+func (n *LanguageNegotiator) Filter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	lang := n.Negotiate(req)
+	req.SetAttribute(n.options.AttributeKey, lang)
+	req.Request = req.Request.WithContext(context.WithValue(req.Request.Context(), n.options.AttributeKey, lang))
+	resp.AddHeader("Content-Language", lang)
+	chain.ProcessFilter(req, resp)
+}
+
+// LanguageFilter 创建请求语言协商过滤器
+//
+//	container.Filter(common.LanguageFilter(bundle, common.LanguageOptions{}))
+func LanguageFilter(bundle *i18n.Bundle, options LanguageOptions) restful.FilterFunction {
+	return NewLanguageNegotiator(bundle, options).Filter
+}
@@ -0,0 +1,385 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"k8s.io/klog/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrIdempotencyKeyInvalid Idempotency-Key 过长
+	ErrIdempotencyKeyInvalid = newLibraryError("idempotencyKeyInvalid", http.StatusBadRequest)
+	// ErrIdempotencyKeyReused 同一个 Idempotency-Key 用于不同的请求
+	ErrIdempotencyKeyReused = newLibraryError("idempotencyKeyReused", http.StatusUnprocessableEntity)
+	// ErrIdempotencyRequestInProgress 相同 Idempotency-Key 的请求正在处理
+	ErrIdempotencyRequestInProgress = newLibraryError("idempotencyRequestInProgress", http.StatusConflict)
+)
+
+// IdempotentReplayedHeader 重放的响应携带该响应头
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// IdempotencyRecord 幂等记录，Completed 为 false 时表示请求正在处理
+// 处理中的记录在 IdempotencyOptions.Lease 后过期，避免请求异常退出后客户端在 TTL 内无法重试
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	Completed   bool
+	Status      int
+	Header      http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore 幂等记录存储
+type IdempotencyStore interface {
+	// Begin 保存处理中的记录，key 已存在且未过期时返回已有记录且 created 为 false
+	Begin(ctx context.Context, record IdempotencyRecord) (existing *IdempotencyRecord, created bool, err error)
+	// Complete 保存响应及新的过期时间
+	Complete(ctx context.Context, record IdempotencyRecord) error
+	// Delete 删除记录，处理失败时调用，以便客户端重试
+	Delete(ctx context.Context, key string) error
+}
+
+// MemoryIdempotencyStore 内存幂等记录存储，只适用于单副本部署
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]IdempotencyRecord
+	lastSweep time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]IdempotencyRecord), lastSweep: time.Now()}
+}
+
+func (s *MemoryIdempotencyStore) Begin(ctx context.Context, record IdempotencyRecord) (existing *IdempotencyRecord, created bool, err error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.lastSweep = now
+		for key, item := range s.records {
+			if now.After(item.ExpiresAt) {
+				delete(s.records, key)
+			}
+		}
+	}
+	if item, exist := s.records[record.Key]; exist && now.Before(item.ExpiresAt) {
+		return &item, false, nil
+	}
+	s.records[record.Key] = record
+	return nil, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, record IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.Key] = record
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// IdempotencyRecordModel GORM 幂等记录表
+type IdempotencyRecordModel struct {
+	Key         string    `gorm:"type:varchar(512);primaryKey" json:"key"`
+	Fingerprint string    `gorm:"type:varchar(64)" json:"fingerprint"`
+	Completed   bool      `json:"completed"`
+	Status      int       `json:"status"`
+	Header      string    `gorm:"type:text" json:"header"`
+	Body        []byte    `gorm:"type:longblob" json:"body"`
+	ExpiresAt   time.Time `gorm:"index" json:"expiresAt"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func (IdempotencyRecordModel) TableName() string {
+	return "idempotency_records"
+}
+
+func (m IdempotencyRecordModel) record() (record IdempotencyRecord, err error) {
+	record = IdempotencyRecord{Key: m.Key, Fingerprint: m.Fingerprint, Completed: m.Completed, Status: m.Status, Body: m.Body, ExpiresAt: m.ExpiresAt}
+	if len(m.Header) > 0 {
+		err = json.Unmarshal([]byte(m.Header), &record.Header)
+	}
+	return record, err
+}
+
+// GormIdempotencyStore 数据库幂等记录存储，过期记录可通过 DeleteExpired 定期清理
+type GormIdempotencyStore struct {
+	db *gorm.DB
+}
+
+func NewGormIdempotencyStore(db *gorm.DB) *GormIdempotencyStore {
+	return &GormIdempotencyStore{db: db}
+}
+
+// AutoMigrate 创建幂等记录表
+func (s *GormIdempotencyStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&IdempotencyRecordModel{})
+}
+
+func (s *GormIdempotencyStore) Begin(ctx context.Context, record IdempotencyRecord) (existing *IdempotencyRecord, created bool, err error) {
+	model := IdempotencyRecordModel{Key: record.Key, Fingerprint: record.Fingerprint, ExpiresAt: record.ExpiresAt}
+	tx := s.db.WithContext(ctx)
+	// 已过期的记录视为不存在
+	if err = tx.Where("`key` = ? AND expires_at < ?", record.Key, time.Now()).Delete(&IdempotencyRecordModel{}).Error; err != nil {
+		return nil, false, err
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil, true, nil
+	}
+	var current IdempotencyRecordModel
+	if err = tx.Where("`key` = ?", record.Key).Take(&current).Error; err != nil {
+		return nil, false, err
+	}
+	item, err := current.record()
+	return &item, false, err
+}
+
+func (s *GormIdempotencyStore) Complete(ctx context.Context, record IdempotencyRecord) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Model(&IdempotencyRecordModel{}).Where("`key` = ?", record.Key).
+		Updates(map[string]interface{}{"completed": true, "status": record.Status, "header": string(header), "body": record.Body, "expires_at": record.ExpiresAt}).Error
+}
+
+func (s *GormIdempotencyStore) Delete(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Where("`key` = ?", key).Delete(&IdempotencyRecordModel{}).Error
+}
+
+// DeleteExpired 删除已过期的记录
+func (s *GormIdempotencyStore) DeleteExpired(ctx context.Context) error {
+	return s.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&IdempotencyRecordModel{}).Error
+}
+
+// IdempotencyOptions 幂等过滤器选项
+type IdempotencyOptions struct {
+	// Store 默认为 NewMemoryIdempotencyStore
+	Store IdempotencyStore
+	// Methods 需要处理 Idempotency-Key 的方法，默认为 POST 及 PATCH
+	Methods []string
+	// TTL 已完成记录的保存时间，默认 24 小时
+	TTL time.Duration
+	// Lease 处理中记录的保存时间，需大于请求的最长处理时间，过期后相同的键可以重新处理，默认 1 分钟
+	Lease time.Duration
+	// MaxBodySize 请求体超过时返回 413，响应体超过时不保存，默认 1MB
+	MaxBodySize int64
+	// Scope 区分不同用户的键，默认为组织及用户名，未认证时为客户端 IP
+	Scope func(req *restful.Request) string
+	// TrustedProxies 受信任的反向代理网段，用于默认 Scope 从 X-Forwarded-For 获取客户端 IP
+	TrustedProxies []string
+	// Bundle 用于本地化错误提示信息，必须设置
+	Bundle *i18n.Bundle
+	// LanguageAttributeKey 请求语言的属性键，默认为 DefaultLanguageAttributeKey
+	LanguageAttributeKey string
+	// ClaimsAttributeKey 认证过滤器保存用户信息的属性键，用于默认 Scope，默认为 DefaultAccountClaimsAttributeKey
+	ClaimsAttributeKey string
+}
+
+// IdempotencyNotStoredHeaders 每次请求都不同的响应头，不保存到幂等记录，重放时使用本次请求的值
+var IdempotencyNotStoredHeaders = []string{"Date", "Set-Cookie", RequestIDHeader, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", IdempotentReplayedHeader}
+
+func idempotencyScope(trustedProxies []*net.IPNet, claimsAttributeKey string) func(req *restful.Request) string {
+	return func(req *restful.Request) string {
+		if claims, exist := GetAccountClaimsFromReqAttribute(req, claimsAttributeKey); exist {
+			if len(claims.Username) > 0 {
+				return fmt.Sprintf("user:%s/%s", claims.Org, claims.Username)
+			}
+			if len(claims.AppClientID) > 0 {
+				return "app:" + claims.AppClientID
+			}
+		}
+		return RateLimitByIP(req, ClientIP(req.Request, trustedProxies), nil)
+	}
+}
+
+// idempotencyRecorder 记录响应体，超过 limit 后不再记录
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (r *idempotencyRecorder) Write(data []byte) (int, error) {
+	if !r.overflow {
+		if int64(r.body.Len()+len(data)) > r.limit {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(data)
+		}
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *idempotencyRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// IdempotencyFilter 处理 Idempotency-Key 请求头，保存第一次请求的响应并在重复请求时重放
+// 相同的键用于不同的请求体时返回 422，第一次请求未完成时返回 409，5xx 响应不保存以便客户端重试
+// 请求体超过 MaxBodySize 时返回 413
+//
+//	filter, err := common.IdempotencyFilter(common.IdempotencyOptions{Store: common.NewGormIdempotencyStore(db), Bundle: bundle})
+//	container.Filter(filter)
+func IdempotencyFilter(options IdempotencyOptions) (restful.FilterFunction, error) {
+	if options.Store == nil {
+		options.Store = NewMemoryIdempotencyStore()
+	}
+	if len(options.Methods) == 0 {
+		options.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	if options.TTL <= 0 {
+		options.TTL = 24 * time.Hour
+	}
+	if options.Lease <= 0 {
+		options.Lease = time.Minute
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = 1 << 20
+	}
+	if options.Scope == nil {
+		trustedProxies, err := parseTrustedProxies(options.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		if len(options.ClaimsAttributeKey) == 0 {
+			options.ClaimsAttributeKey = DefaultAccountClaimsAttributeKey
+		}
+		options.Scope = idempotencyScope(trustedProxies, options.ClaimsAttributeKey)
+	}
+	if len(options.LanguageAttributeKey) == 0 {
+		options.LanguageAttributeKey = DefaultLanguageAttributeKey
+	}
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		key := req.HeaderParameter(IdempotencyKeyHeader)
+		if len(key) == 0 || !StringInArray(req.Request.Method, options.Methods) {
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		ctx := req.Request.Context()
+		lang := GetLanguageFromReq(req, options.LanguageAttributeKey)
+		if len(key) > 255 {
+			ResponseErrorMessage(ctx, req, resp, options.Bundle, ErrIdempotencyKeyInvalid.New(nil).ErrorData(lang))
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(req.Request.Body, options.MaxBodySize+1))
+		if err != nil {
+			ResponseErrorMessage(ctx, req, resp, options.Bundle, NewErrorData(err, lang))
+			return
+		}
+		// 指纹需要覆盖完整的请求体，超过 MaxBodySize 的请求不处理
+		if int64(len(body)) > options.MaxBodySize {
+			err = ErrRequestBodyTooLarge.Wrap(fmt.Errorf("request body exceeds %d bytes", options.MaxBodySize), nil)
+			ResponseErrorMessage(ctx, req, resp, options.Bundle, NewErrorData(err, lang))
+			return
+		}
+		req.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		hash.Write([]byte(req.Request.Method + " " + req.Request.URL.RequestURI() + "\n"))
+		hash.Write(body)
+		record := IdempotencyRecord{
+			Key:         options.Scope(req) + "|" + key,
+			Fingerprint: hex.EncodeToString(hash.Sum(nil)),
+			ExpiresAt:   time.Now().Add(options.Lease),
+		}
+		existing, created, err := options.Store.Begin(ctx, record)
+		if err != nil {
+			// 存储不可用时按普通请求处理
+			klog.Errorf("begin idempotency record failed, key: %s, err: %s", record.Key, err.Error())
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		if !created {
+			switch {
+			case existing.Fingerprint != record.Fingerprint:
+				ResponseErrorMessage(ctx, req, resp, options.Bundle, ErrIdempotencyKeyReused.New(nil).ErrorData(lang))
+			case !existing.Completed:
+				ResponseErrorMessage(ctx, req, resp, options.Bundle, ErrIdempotencyRequestInProgress.New(nil).ErrorData(lang))
+			default:
+				// 保存的响应头替换当前的值，而不是追加
+				for name, values := range existing.Header {
+					resp.Header()[name] = values
+				}
+				resp.Header().Set(IdempotentReplayedHeader, "true")
+				resp.WriteHeader(existing.Status)
+				_, _ = resp.Write(existing.Body)
+			}
+			return
+		}
+
+		recorder := &idempotencyRecorder{ResponseWriter: resp.ResponseWriter, limit: options.MaxBodySize}
+		resp.ResponseWriter = recorder
+		completed := false
+		defer func() {
+			resp.ResponseWriter = recorder.ResponseWriter
+			if completed {
+				return
+			}
+			// panic 或响应无法保存时删除记录
+			if er := options.Store.Delete(context.Background(), record.Key); er != nil {
+				klog.Errorf("delete idempotency record failed, key: %s, err: %s", record.Key, er.Error())
+			}
+		}()
+		chain.ProcessFilter(req, resp)
+		status := resp.StatusCode()
+		if status >= http.StatusInternalServerError || recorder.overflow {
+			return
+		}
+		record.Completed = true
+		record.Status = status
+		record.Header = resp.Header().Clone()
+		for _, name := range IdempotencyNotStoredHeaders {
+			record.Header.Del(name)
+		}
+		record.Body = recorder.body.Bytes()
+		record.ExpiresAt = time.Now().Add(options.TTL)
+		if err = options.Store.Complete(context.Background(), record); err != nil {
+			klog.Errorf("complete idempotency record failed, key: %s, err: %s", record.Key, err.Error())
+			return
+		}
+		completed = true
+	}, nil
+}
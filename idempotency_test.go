@@ -0,0 +1,183 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/language"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// idempotencyHandler 按请求体返回响应，请求体为 fail 时返回 500，为 panic 时 panic，为 wait 时等待 release
+type idempotencyHandler struct {
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *idempotencyHandler) handle(req *restful.Request, resp *restful.Response) {
+	calls := atomic.AddInt32(&h.calls, 1)
+	body, _ := io.ReadAll(req.Request.Body)
+	switch string(body) {
+	case "fail":
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	case "panic":
+		panic("handler panic")
+	case "wait":
+		h.started <- struct{}{}
+		<-h.release
+	}
+	resp.AddHeader("X-Call", strconv.Itoa(int(calls)))
+	resp.AddHeader("Set-Cookie", "session="+strconv.Itoa(int(calls)))
+	resp.WriteHeader(http.StatusCreated)
+	_, _ = resp.Write([]byte("created:" + string(body)))
+}
+
+func newIdempotencyContainer(t *testing.T, options IdempotencyOptions) (*restful.Container, *idempotencyHandler) {
+	bundle := i18n.NewBundle(language.Chinese)
+	if err := AddDefaultMessages(bundle); err != nil {
+		t.Fatal(err)
+	}
+	options.Bundle = bundle
+	filter, err := IdempotencyFilter(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &idempotencyHandler{started: make(chan struct{}, 1), release: make(chan struct{})}
+	ws := new(restful.WebService)
+	ws.Route(ws.POST("/orders").To(handler.handle))
+	container := restful.NewContainer()
+	container.DoNotRecover(false)
+	container.Add(ws)
+	container.Filter(filter)
+	return container, handler
+}
+
+func idempotencyServe(container *restful.Container, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	req.RemoteAddr = "203.0.113.9:1234"
+	if len(key) > 0 {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestIdempotencyFilterReplay(t *testing.T) {
+	container, handler := newIdempotencyContainer(t, IdempotencyOptions{})
+	first := idempotencyServe(container, "k1", "a")
+	second := idempotencyServe(container, "k1", "a")
+	if handler.calls != 1 {
+		t.Fatalf("handler calls: %d, expect 1", handler.calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() || second.Header().Get("X-Call") != "1" {
+		t.Fatalf("replayed code: %d, body: %s, headers: %v", second.Code, second.Body.String(), second.Header())
+	}
+	if second.Header().Get(IdempotentReplayedHeader) != "true" || len(first.Header().Get(IdempotentReplayedHeader)) > 0 {
+		t.Fatalf("only the replayed response should carry %s", IdempotentReplayedHeader)
+	}
+	if len(second.Header().Get("Set-Cookie")) > 0 {
+		t.Fatalf("Set-Cookie should not be replayed: %v", second.Header())
+	}
+	// 未携带 Idempotency-Key 的请求不处理
+	idempotencyServe(container, "", "a")
+	idempotencyServe(container, "", "a")
+	if handler.calls != 3 {
+		t.Fatalf("handler calls: %d, expect 3", handler.calls)
+	}
+}
+
+func TestIdempotencyFilterRejects(t *testing.T) {
+	container, handler := newIdempotencyContainer(t, IdempotencyOptions{MaxBodySize: 16})
+	idempotencyServe(container, "k1", "a")
+	if recorder := idempotencyServe(container, "k1", "b"); recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("code: %d, a reused key should be rejected with 422", recorder.Code)
+	}
+	if recorder := idempotencyServe(container, "k2", strings.Repeat("x", 17)); recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("code: %d, a body larger than MaxBodySize should be rejected with 413", recorder.Code)
+	}
+	if recorder := idempotencyServe(container, strings.Repeat("k", 256), "a"); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("code: %d, a long key should be rejected with 400", recorder.Code)
+	}
+	if handler.calls != 1 {
+		t.Fatalf("handler calls: %d, expect 1", handler.calls)
+	}
+}
+
+func TestIdempotencyFilterInProgress(t *testing.T) {
+	container, handler := newIdempotencyContainer(t, IdempotencyOptions{})
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		done <- idempotencyServe(container, "k1", "wait")
+	}()
+	<-handler.started
+	if recorder := idempotencyServe(container, "k1", "wait"); recorder.Code != http.StatusConflict {
+		t.Fatalf("code: %d, expect 409 while the first request is in progress", recorder.Code)
+	}
+	handler.release <- struct{}{}
+	if recorder := <-done; recorder.Code != http.StatusCreated {
+		t.Fatalf("code: %d, expect 201", recorder.Code)
+	}
+}
+
+func TestIdempotencyFilterDeletesFailures(t *testing.T) {
+	container, handler := newIdempotencyContainer(t, IdempotencyOptions{})
+	for _, body := range []string{"fail", "panic"} {
+		for i := 0; i < 2; i++ {
+			if recorder := idempotencyServe(container, body, body); recorder.Code != http.StatusInternalServerError {
+				t.Fatalf("body: %s, code: %d, expect 500", body, recorder.Code)
+			}
+		}
+	}
+	// 失败的请求不保存记录，相同的键可以重试
+	if handler.calls != 4 {
+		t.Fatalf("handler calls: %d, expect 4", handler.calls)
+	}
+}
+
+func TestIdempotencyFilterLease(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	container, handler := newIdempotencyContainer(t, IdempotencyOptions{Store: store, Lease: 20 * time.Millisecond})
+	// 模拟异常退出未完成的请求
+	record := IdempotencyRecord{Key: "ip:203.0.113.9|k1", Fingerprint: "x", ExpiresAt: time.Now().Add(20 * time.Millisecond)}
+	if _, created, _ := store.Begin(context.Background(), record); !created {
+		t.Fatal("record should be created")
+	}
+	if recorder := idempotencyServe(container, "k1", "a"); recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("code: %d, expect 422 before the lease expires", recorder.Code)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if recorder := idempotencyServe(container, "k1", "a"); recorder.Code != http.StatusCreated {
+		t.Fatalf("code: %d, expect 201 after the lease expires", recorder.Code)
+	}
+	// 完成的记录按 TTL 保存
+	time.Sleep(30 * time.Millisecond)
+	if recorder := idempotencyServe(container, "k1", "a"); recorder.Header().Get(IdempotentReplayedHeader) != "true" || handler.calls != 1 {
+		t.Fatalf("completed record should be kept for TTL, calls: %d", handler.calls)
+	}
+}
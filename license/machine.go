@@ -17,13 +17,14 @@ limitations under the License.
 package license
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/denisbrodbeck/machineid"
 	"github.com/efucloud/common"
 	"github.com/golang-jwt/jwt/v5"
 	"go.uber.org/zap"
-	"io"
 	"net/http"
 	"os"
 	"path"
@@ -90,31 +91,28 @@ func GetMachineInformation(appName string, logger *zap.SugaredLogger) (applicati
 		//获取k8s版本信息
 		verAddr := fmt.Sprintf("https://%s:%s/version", applicationInfo.KubernetesInfo.Server, applicationInfo.KubernetesInfo.Port)
 		logger.Infof("get kubernetes version from: %s", verAddr)
-		headers := make(map[string]string)
-		headers["Authorization"] = "Bearer " + tokenStr
-		if response, err := common.Request(http.MethodGet, verAddr, headers, nil, nil); err == nil {
-			body, err := io.ReadAll(response.Body)
-			logger.Info(err)
-			logger.Infof("get kubernetes version response: %s", string(body))
-			if response.StatusCode == http.StatusOK {
-				var ver common.K8sVersion
-				err = json.Unmarshal(body, &ver)
-				if err != nil {
-					logger.Error(err)
-					applicationInfo.Error = err.Error()
-					return
-				} else {
-					applicationInfo.KubernetesInfo.Version = &ver
-
-				}
-			} else {
-				logger.Errorf("get kubernetes version response: %s", string(body))
-			}
-		} else {
+		options := common.DefaultHTTPClientOptions()
+		options.CAData = []string{string(ca)}
+		options.BearerToken = tokenStr
+		client, err := common.NewHTTPClient(options)
+		if err != nil {
 			logger.Error(err)
 			applicationInfo.Error = err.Error()
 			return
 		}
+		ver, err := common.DoJSON[common.K8sVersion](context.Background(), client, http.MethodGet, verAddr, nil, nil, nil, common.DefaultRetryPolicy())
+		if err != nil {
+			var remoteErr *common.RemoteError
+			if errors.As(err, &remoteErr) {
+				logger.Errorf("get kubernetes version response: %s", string(remoteErr.Body))
+			} else {
+				logger.Error(err)
+				applicationInfo.Error = err.Error()
+				return
+			}
+		} else {
+			applicationInfo.KubernetesInfo.Version = &ver
+		}
 	} else {
 		logger.Infof("current run system is: %s", runtime.GOOS)
 		// 只判断为linux时判断是否docker运行
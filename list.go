@@ -0,0 +1,146 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"github.com/emicklei/go-restful/v3"
+	"gorm.io/gorm"
+)
+
+// DefaultMaxPageSize ListOptions.MaxPageSize 的默认值
+const DefaultMaxPageSize = 500
+
+// ListOptions 列表查询选项
+type ListOptions struct {
+	// Query 带 query 标签的查询结构体，用于从请求中生成查询条件
+	Query interface{}
+	// Filter 额外的查询条件，如组织、权限等由服务端决定的条件，字段不受限制，不能使用客户端提交的条件
+	Filter *Filter
+	// Search 客户端提交的高级查询条件，字段必须为 T 的 json 字段，见 Filter.BuildFor
+	Search *Filter
+	// Preloads 需要预加载的关联
+	Preloads []string
+	// Selects 需要查询的字段，为空时查询所有字段
+	Selects []string
+	// Cursor 不为空时使用游标分页，否则使用 current/pageSize 分页
+	Cursor *CursorCodec
+	// SkipCount 不统计总数，大表游标分页时使用
+	SkipCount bool
+	// MaxPageSize 客户端请求的 pageSize 上限，超过时使用该值，默认为 DefaultMaxPageSize
+	MaxPageSize int
+}
+
+// ListResponse 组合查询条件、分页、排序及总数统计，返回填充好的 ResponseList
+// 统计总数与分页查询使用同一个 ctx，查询条件相同但互不影响
+// 查询条件在查询数据库前渲染，条件无效时返回 ErrInvalidQueryParameter
+func ListResponse[T any](ctx context.Context, db *gorm.DB, req *restful.Request, options ListOptions) (list ResponseList, err error) {
+	var model T
+	rows := make([]T, 0)
+	if options.MaxPageSize <= 0 {
+		options.MaxPageSize = DefaultMaxPageSize
+	}
+	tx := db.WithContext(ctx).Model(&model)
+	if options.Query != nil {
+		filter, er := BindQueryFilter(req, options.Query)
+		if er != nil {
+			return list, er
+		}
+		if tx, er = listWhere(tx, filter.Build); er != nil {
+			return list, er
+		}
+	}
+	if options.Filter != nil {
+		if tx, err = listWhere(tx, options.Filter.Build); err != nil {
+			return list, err
+		}
+	}
+	if options.Search != nil {
+		if tx, err = listWhere(tx, func() (string, []interface{}, error) { return options.Search.BuildFor(&model) }); err != nil {
+			return list, err
+		}
+	}
+	if options.Cursor != nil {
+		page, er := GetRequestCursorPagination(req, options.Cursor, &model)
+		if er != nil {
+			return list, er
+		}
+		if page.PageSize > options.MaxPageSize {
+			page.PageSize = options.MaxPageSize
+		}
+		if !options.SkipCount {
+			if err = tx.Session(&gorm.Session{}).Count(&list.Total).Error; err != nil {
+				return list, err
+			}
+		}
+		if err = listSelect(tx.Session(&gorm.Session{}), options).Scopes(page.Scope()).Find(&rows).Error; err != nil {
+			return list, err
+		}
+		list.NextCursor, list.PrevCursor, err = page.Complete(&rows)
+		list.Data = rows
+		return list, err
+	}
+	current, pageSize, sorts, err := GetRequestPaginationSorts(req, &model)
+	if err != nil {
+		return list, err
+	}
+	if pageSize > options.MaxPageSize {
+		pageSize = options.MaxPageSize
+	}
+	if !options.SkipCount {
+		if err = tx.Session(&gorm.Session{}).Count(&list.Total).Error; err != nil {
+			return list, err
+		}
+		if list.Total == 0 {
+			list.Data = rows
+			return list, nil
+		}
+	}
+	err = listSelect(tx.Session(&gorm.Session{}), options).
+		Clauses(sorts.OrderBy()).
+		Offset((current - 1) * pageSize).
+		Limit(pageSize).
+		Find(&rows).Error
+	list.Data = rows
+	return list, err
+}
+
+// listWhere 渲染条件并追加到 tx，渲染失败时返回 ErrInvalidQueryParameter，而不是在查询时返回数据库错误
+func listWhere(tx *gorm.DB, build func() (string, []interface{}, error)) (*gorm.DB, error) {
+	query, args, err := build()
+	if err != nil {
+		if errors.Is(err, ErrInvalidQueryParameter) {
+			return tx, err
+		}
+		return tx, ErrInvalidQueryParameter.Wrap(err, nil)
+	}
+	if len(query) == 0 {
+		return tx, nil
+	}
+	return tx.Where(query, args...), nil
+}
+
+func listSelect(tx *gorm.DB, options ListOptions) *gorm.DB {
+	if len(options.Selects) > 0 {
+		tx = tx.Select(options.Selects)
+	}
+	for _, preload := range options.Preloads {
+		tx = tx.Preload(preload)
+	}
+	return tx
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/emicklei/go-restful/v3"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type listAccount struct {
+	ID    uint       `json:"id"`
+	Name  string     `json:"name"`
+	Roles []listRole `json:"roles" gorm:"foreignKey:AccountID"`
+}
+
+type listRole struct {
+	ID        uint   `json:"id"`
+	AccountID uint   `json:"accountId"`
+	Name      string `json:"name"`
+}
+
+type listAccountQuery struct {
+	Name string `query:"name,op=like"`
+	Age  []int  `query:"age,op=between"`
+}
+
+// listResult 测试驱动按 SQL 前缀返回的结果
+type listResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// listDriver 记录执行的 SQL，并按最长匹配的 SQL 前缀返回预设结果，未匹配时返回空结果
+type listDriver struct {
+	mu      sync.Mutex
+	queries []string
+	results map[string]listResult
+}
+
+func (d *listDriver) Connect(context.Context) (driver.Conn, error) { return &listConn{driver: d}, nil }
+func (d *listDriver) Driver() driver.Driver                        { return nil }
+
+func (d *listDriver) query(query string) listResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries = append(d.queries, query)
+	var matched string
+	for prefix := range d.results {
+		if strings.HasPrefix(query, prefix) && len(prefix) > len(matched) {
+			matched = prefix
+		}
+	}
+	return d.results[matched]
+}
+
+func (d *listDriver) executed() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queries...)
+}
+
+type listConn struct {
+	driver *listDriver
+}
+
+func (c *listConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c *listConn) Close() error              { return nil }
+func (c *listConn) Begin() (driver.Tx, error) { return nil, errors.New("transaction not supported") }
+
+func (c *listConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	result := c.driver.query(query)
+	return &listRows{columns: result.columns, rows: result.rows}, nil
+}
+
+type listRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *listRows) Columns() []string { return r.columns }
+func (r *listRows) Close() error      { return nil }
+
+func (r *listRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func newListDB(t *testing.T, results map[string]listResult) (*gorm.DB, *listDriver) {
+	fake := &listDriver{results: results}
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sql.OpenDB(fake), SkipInitializeWithVersion: true}), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, fake
+}
+
+func listRequest(query url.Values) *restful.Request {
+	return restful.NewRequest(httptest.NewRequest(http.MethodGet, "/accounts?"+query.Encode(), nil))
+}
+
+func listAccountRows(ids ...int64) listResult {
+	result := listResult{columns: []string{"id", "name"}}
+	for _, id := range ids {
+		result.rows = append(result.rows, []driver.Value{id, "account"})
+	}
+	return result
+}
+
+func TestListResponsePage(t *testing.T) {
+	db, fake := newListDB(t, map[string]listResult{
+		"SELECT count(*)": {columns: []string{"count(*)"}, rows: [][]driver.Value{{int64(12)}}},
+		"SELECT":          listAccountRows(6, 5),
+	})
+	req := listRequest(url.Values{"current": {"3"}, "pageSize": {"2"}, "order": {"-id"}, "name": {"adm"}})
+	filter := FilterAnd(FilterCondition("org_id", QueryTypeEqual, 1))
+	list, err := ListResponse[listAccount](context.Background(), db, req, ListOptions{Query: &listAccountQuery{}, Filter: &filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"SELECT count(*) FROM `list_accounts` WHERE (name LIKE ?) AND (org_id = ?)",
+		"SELECT * FROM `list_accounts` WHERE (name LIKE ?) AND (org_id = ?) ORDER BY `id` DESC LIMIT 2 OFFSET 4",
+	}
+	if queries := fake.executed(); !reflect.DeepEqual(queries, expect) {
+		t.Fatalf("queries: %q, expect: %q", queries, expect)
+	}
+	rows, _ := list.Data.([]listAccount)
+	if list.Total != 12 || len(rows) != 2 || rows[0].ID != 6 {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+}
+
+func TestListResponseEmptyCount(t *testing.T) {
+	db, fake := newListDB(t, map[string]listResult{
+		"SELECT count(*)": {columns: []string{"count(*)"}, rows: [][]driver.Value{{int64(0)}}},
+	})
+	list, err := ListResponse[listAccount](context.Background(), db, listRequest(nil), ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queries := fake.executed(); len(queries) != 1 {
+		t.Fatalf("rows should not be queried when total is 0: %q", queries)
+	}
+	if rows, ok := list.Data.([]listAccount); !ok || rows == nil || len(rows) != 0 {
+		t.Fatalf("data should be an empty slice: %#v", list.Data)
+	}
+}
+
+func TestListResponseSelectPreload(t *testing.T) {
+	db, fake := newListDB(t, map[string]listResult{
+		"SELECT `id`,`name` FROM `list_accounts`": listAccountRows(1, 2),
+		"SELECT * FROM `list_roles`": {columns: []string{"id", "account_id", "name"}, rows: [][]driver.Value{
+			{int64(10), int64(1), "admin"}, {int64(11), int64(2), "guest"},
+		}},
+	})
+	list, err := ListResponse[listAccount](context.Background(), db, listRequest(url.Values{"pageSize": {"1000"}}), ListOptions{
+		Selects: []string{"id", "name"}, Preloads: []string{"Roles"}, SkipCount: true, MaxPageSize: 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"SELECT `id`,`name` FROM `list_accounts` ORDER BY `id` DESC LIMIT 50",
+		"SELECT * FROM `list_roles` WHERE `list_roles`.`account_id` IN (?,?)",
+	}
+	if queries := fake.executed(); !reflect.DeepEqual(queries, expect) {
+		t.Fatalf("queries: %q, expect: %q", queries, expect)
+	}
+	rows, _ := list.Data.([]listAccount)
+	if len(rows) != 2 || len(rows[1].Roles) != 1 || rows[1].Roles[0].Name != "guest" {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+}
+
+func TestListResponseCursor(t *testing.T) {
+	db, fake := newListDB(t, map[string]listResult{
+		"SELECT count(*)": {columns: []string{"count(*)"}, rows: [][]driver.Value{{int64(3)}}},
+		"SELECT":          listAccountRows(1, 2, 3),
+	})
+	codec := NewCursorCodec([]byte("secret"))
+	list, err := ListResponse[listAccount](context.Background(), db, listRequest(url.Values{"pageSize": {"2"}}), ListOptions{Cursor: codec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := fake.executed()
+	if len(queries) != 2 || !strings.HasSuffix(queries[1], "ORDER BY `id` DESC LIMIT 3") {
+		t.Fatalf("unexpected queries: %q", queries)
+	}
+	rows, _ := list.Data.([]listAccount)
+	if list.Total != 3 || len(rows) != 2 || len(list.NextCursor) == 0 || len(list.PrevCursor) != 0 {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+	cursor, err := codec.Decode(list.NextCursor)
+	if err != nil || string(cursor.Values[0]) != "2" {
+		t.Fatalf("unexpected next cursor: %+v, err: %v", cursor, err)
+	}
+
+	db, fake = newListDB(t, nil)
+	if _, err = ListResponse[listAccount](context.Background(), db, listRequest(url.Values{"pageSize": {"1000"}}), ListOptions{Cursor: codec, SkipCount: true, MaxPageSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if queries = fake.executed(); len(queries) != 1 || !strings.HasSuffix(queries[0], "LIMIT 11") {
+		t.Fatalf("page size should be capped: %q", queries)
+	}
+}
+
+func TestListResponseInvalidFilter(t *testing.T) {
+	between := FilterAnd(FilterCondition("age", QueryTypeBetween, []interface{}{1}))
+	password := FilterAnd(FilterCondition("password", QueryTypeEqual, "x"))
+	cases := []struct {
+		query   url.Values
+		options ListOptions
+	}{
+		{url.Values{"age[]": {"18"}}, ListOptions{Query: &listAccountQuery{}}},
+		{nil, ListOptions{Filter: &between}},
+		{nil, ListOptions{Search: &password}},
+	}
+	for _, item := range cases {
+		db, fake := newListDB(t, nil)
+		_, err := ListResponse[listAccount](context.Background(), db, listRequest(item.query), item.options)
+		if !errors.Is(err, ErrInvalidQueryParameter) {
+			t.Fatalf("options: %+v, err: %v should be ErrInvalidQueryParameter", item.options, err)
+		}
+		if data := NewErrorData(err, I18nZH); data.ResponseCode != 400 {
+			t.Fatalf("options: %+v, status: %d, expect 400", item.options, data.ResponseCode)
+		}
+		if queries := fake.executed(); len(queries) != 0 {
+			t.Fatalf("invalid filters should not reach the database: %q", queries)
+		}
+	}
+}
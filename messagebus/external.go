@@ -14,4 +14,6 @@ const (
 	TopicOrganizationAccount     = "/messagebus/eauth/account"
 	TopicOrganizationWorkspace   = "/messagebus/eauth/workspace"
 	TopicOrganizationApplication = "/messagebus/eauth/application"
+	// TopicAudit 审计事件，消息为 common.AuditEvent
+	TopicAudit = "/messagebus/audit/event"
 )
@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"github.com/emicklei/go-restful/v3"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const MIME_PROBLEM_JSON = "application/problem+json"
+
+// ProblemTypeBaseURI 不为空时 problem 的 type 为 ProblemTypeBaseURI + MsgCode，否则为 about:blank
+var ProblemTypeBaseURI = ""
+
+// ProblemDetails RFC 7807 错误信息，Alert 及 MsgCode 为扩展字段
+type ProblemDetails struct {
+	Type      string            `json:"type" description:"错误类型"`
+	Title     string            `json:"title" description:"错误标题"`
+	Status    int               `json:"status" description:"响应码"`
+	Detail    string            `json:"detail,omitempty" description:"错误详情信息"`
+	Instance  string            `json:"instance,omitempty" description:"当前请求地址"`
+	Alert     string            `json:"alert,omitempty" description:"支持I18N的提示信息"`
+	MsgCode   string            `json:"msgCode,omitempty" description:"错误英文编码"`
+	ErrorID   string            `json:"errorId,omitempty" description:"错误ID，用于在服务端日志中查找错误详情"`
+	RequestID string            `json:"requestId,omitempty" description:"请求ID，用于跨服务追踪请求"`
+	Fields    map[string]string `json:"fields,omitempty" description:"参数校验失败的字段及翻译后的提示信息"`
+}
+
+// NewProblemDetails 由 ResponseError 生成 RFC 7807 错误信息
+func NewProblemDetails(status int, body ResponseError) ProblemDetails {
+	problem := ProblemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    body.Detail,
+		Instance:  body.RequestURI,
+		Alert:     body.Alert,
+		MsgCode:   body.Message,
+		ErrorID:   body.ErrorID,
+		RequestID: body.RequestID,
+		Fields:    body.Fields,
+	}
+	if len(ProblemTypeBaseURI) > 0 && len(body.Message) > 0 {
+		problem.Type = URL(ProblemTypeBaseURI, body.Message)
+	}
+	return problem
+}
+
+// AcceptProblemJSON 请求头 Accept 中明确包含 application/problem+json 时返回 true
+func AcceptProblemJSON(req *restful.Request) bool {
+	for _, item := range strings.Split(req.HeaderParameter(restful.HEADER_Accept), ",") {
+		params := strings.Split(item, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), MIME_PROBLEM_JSON) {
+			continue
+		}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil && q <= 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
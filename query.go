@@ -0,0 +1,252 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"github.com/emicklei/go-restful/v3"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// queryField 查询结构体字段的 query 标签信息
+// 如 `query:"name,op=like"`、`query:"status,op=in,type=numberSlice"`、`query:"search,op=like,fields=name;nickname"`
+type queryField struct {
+	Name        string
+	Operator    string
+	ParamType   string
+	Column      string
+	Fields      []string
+	Description string
+}
+
+// multiple in/notIn/between 使用 name[] 形式传递多个值，与 RequestQuery 保持一致
+func (q queryField) multiple() bool {
+	return q.Operator == QueryTypeIn || q.Operator == QueryTypeNotIn || q.Operator == QueryTypeBetween
+}
+
+func (q queryField) paramName() string {
+	if q.multiple() {
+		return q.Name + "[]"
+	}
+	return q.Name
+}
+
+func parseQueryFields(v interface{}) (fields []queryField) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, exist := field.Tag.Lookup("query")
+		if !exist {
+			if field.Anonymous {
+				fields = append(fields, parseQueryFields(reflect.New(field.Type).Elem().Interface())...)
+			}
+			continue
+		}
+		if tag == "-" {
+			continue
+		}
+		options := strings.Split(tag, ",")
+		item := queryField{Name: strings.TrimSpace(options[0]), Operator: QueryTypeEqual}
+		if len(item.Name) == 0 {
+			item.Name = strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		}
+		if len(item.Name) == 0 || item.Name == "-" {
+			item.Name = field.Name
+		}
+		for _, option := range options[1:] {
+			kv := strings.SplitN(strings.TrimSpace(option), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "op":
+				item.Operator = kv[1]
+			case "type":
+				item.ParamType = kv[1]
+			case "column":
+				item.Column = kv[1]
+			case "fields":
+				item.Fields = strings.Split(kv[1], ";")
+			}
+		}
+		if len(item.ParamType) == 0 {
+			item.ParamType = queryParamType(field.Type)
+		}
+		item.Description = field.Tag.Get("description")
+		fields = append(fields, item)
+	}
+	return fields
+}
+
+func queryParamType(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return ParamTypeBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return ParamTypeNumber
+	case reflect.Slice, reflect.Array:
+		if queryParamType(t.Elem()) == ParamTypeNumber {
+			return ParamTypeNumberSlice
+		}
+		return ParamTypeStringSlice
+	default:
+		return ParamTypeString
+	}
+}
+
+func queryValue(name, paramType, value string) (result interface{}, err error) {
+	switch paramType {
+	case ParamTypeNumber, ParamTypeNumberSlice:
+		if result, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("query parameter: %s, value: %s is not a number", name, value)
+		}
+		return result, nil
+	case ParamTypeBool:
+		if value == "0" || strings.ToUpper(value) == "F" || strings.ToUpper(value) == "FALSE" {
+			return 0, nil
+		}
+		return 1, nil
+	default:
+		return value, nil
+	}
+}
+
+// BindQueryFilter 根据查询结构体的 query 标签从请求中生成查询条件，未传递的参数会被忽略
+// 参数值类型错误或条件无效时返回 ErrInvalidQueryParameter
+func BindQueryFilter(req *restful.Request, v interface{}) (filter Filter, err error) {
+	filter = FilterAnd()
+	for _, item := range parseQueryFields(v) {
+		column := item.Column
+		if len(column) == 0 {
+			column = item.Name
+		}
+		if len(item.Fields) > 0 {
+			value := strings.TrimSpace(req.QueryParameter(item.Name))
+			if len(value) == 0 {
+				continue
+			}
+			group := FilterOr()
+			for _, name := range item.Fields {
+				group.Add(FilterCondition(name, item.Operator, value))
+			}
+			filter.Add(group)
+			continue
+		}
+		if item.Operator == QueryTypeIsNull || item.Operator == QueryTypeNotNull {
+			value := strings.TrimSpace(req.QueryParameter(item.Name))
+			if len(value) == 0 {
+				continue
+			}
+			if enabled, _ := queryValue(item.Name, ParamTypeBool, value); enabled == 0 {
+				continue
+			}
+			filter.Add(FilterCondition(column, item.Operator, nil))
+			continue
+		}
+		if item.multiple() {
+			var values []interface{}
+			for _, value := range req.QueryParameters(item.paramName()) {
+				value = strings.TrimSpace(value)
+				if len(value) == 0 {
+					continue
+				}
+				arg, er := queryValue(item.Name, item.ParamType, value)
+				if er != nil {
+					return filter, ErrInvalidQueryParameter.Wrap(er, nil)
+				}
+				values = append(values, arg)
+			}
+			if len(values) == 0 {
+				continue
+			}
+			filter.Add(FilterCondition(column, item.Operator, values))
+			continue
+		}
+		value := strings.TrimSpace(req.QueryParameter(item.Name))
+		if len(value) == 0 {
+			continue
+		}
+		arg, er := queryValue(item.Name, item.ParamType, value)
+		if er != nil {
+			return filter, ErrInvalidQueryParameter.Wrap(er, nil)
+		}
+		filter.Add(FilterCondition(column, item.Operator, arg))
+	}
+	// 绑定时校验条件，如 between 的值数量，避免在查询时才返回数据库错误
+	if _, _, err = filter.Build(); err != nil {
+		return filter, ErrInvalidQueryParameter.Wrap(err, nil)
+	}
+	return filter, nil
+}
+
+// BindQueryParam 根据查询结构体的 query 标签从请求中生成查询条件，并以 AND 方式追加到 QueryParam
+func BindQueryParam(req *restful.Request, v interface{}, queryParam *QueryParam) error {
+	filter, err := BindQueryFilter(req, v)
+	if err != nil {
+		return err
+	}
+	return filter.ToQueryParam(queryParam)
+}
+
+// QueryParameters 根据查询结构体生成 restful 查询参数文档，参数说明取自 description 标签
+func QueryParameters(ws *restful.WebService, v interface{}) (params []*restful.Parameter) {
+	for _, item := range parseQueryFields(v) {
+		description := item.Description
+		if len(description) == 0 {
+			description = item.Name
+		}
+		param := ws.QueryParameter(item.paramName(), description)
+		switch item.ParamType {
+		case ParamTypeNumber, ParamTypeNumberSlice:
+			param.DataType("integer")
+		case ParamTypeBool:
+			param.DataType("boolean")
+		default:
+			param.DataType("string")
+		}
+		if item.multiple() {
+			param.AllowMultiple(true).CollectionFormat(restful.CollectionFormatMulti)
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+// QueryParametersBuilder 用于 RouteBuilder.Do，将查询结构体的参数文档注册到路由
+// 如 ws.GET("/").Do(common.QueryParametersBuilder(ws, AccountQuery{}))
+func QueryParametersBuilder(ws *restful.WebService, v interface{}) func(builder *restful.RouteBuilder) {
+	return func(builder *restful.RouteBuilder) {
+		for _, param := range QueryParameters(ws, v) {
+			builder.Param(param)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package common
+
+import (
+	"errors"
+	"github.com/emicklei/go-restful/v3"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type queryPage struct {
+	Current int `query:"-"`
+}
+
+type accountQuery struct {
+	queryPage
+	Name    string   `json:"name" query:",op=like" description:"名称"`
+	Status  []uint   `query:"status,op=in"`
+	Age     []int    `query:"age,op=between"`
+	Enabled bool     `query:"enabled"`
+	Deleted bool     `query:"deletedAt,op=isNull"`
+	Org     string   `query:"org,column=org_code"`
+	Keyword string   `query:"keyword,op=like,fields=name;nickname"`
+	Roles   []string `query:"roles,op=notIn"`
+	Remark  string
+}
+
+func newQueryRequest(rawQuery string) *restful.Request {
+	return restful.NewRequest(httptest.NewRequest("GET", "/accounts?"+rawQuery, nil))
+}
+
+func TestParseQueryFields(t *testing.T) {
+	fields := parseQueryFields(&accountQuery{})
+	names := make(map[string]queryField)
+	for _, item := range fields {
+		names[item.Name] = item
+	}
+	if len(fields) != 8 {
+		t.Fatalf("unexpected fields: %+v", fields)
+	}
+	if item := names["name"]; item.Operator != QueryTypeLike || item.ParamType != ParamTypeString || item.Description != "名称" {
+		t.Fatalf("unexpected name field: %+v", item)
+	}
+	if item := names["status"]; item.ParamType != ParamTypeNumberSlice || item.paramName() != "status[]" {
+		t.Fatalf("unexpected status field: %+v", item)
+	}
+	if item := names["roles"]; item.ParamType != ParamTypeStringSlice || !item.multiple() {
+		t.Fatalf("unexpected roles field: %+v", item)
+	}
+	if item := names["enabled"]; item.Operator != QueryTypeEqual || item.ParamType != ParamTypeBool {
+		t.Fatalf("unexpected enabled field: %+v", item)
+	}
+	if item := names["org"]; item.Column != "org_code" {
+		t.Fatalf("unexpected org field: %+v", item)
+	}
+	if item := names["keyword"]; !reflect.DeepEqual(item.Fields, []string{"name", "nickname"}) {
+		t.Fatalf("unexpected keyword field: %+v", item)
+	}
+}
+
+func TestBindQueryFilter(t *testing.T) {
+	req := newQueryRequest("name=adm&status[]=1&status[]=2&age[]=18&age[]=30&enabled=false&deletedAt=true&org=efucloud&keyword=bob&roles[]=guest")
+	filter, err := BindQueryFilter(req, &accountQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, args, err := filter.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectQuery := "(name LIKE ?) AND (status IN (?)) AND (age BETWEEN ? AND ?) AND (enabled = ?) AND (deleted_at IS NULL) AND (org_code = ?) AND ((name LIKE ?) OR (nickname LIKE ?)) AND (roles NOT IN (?))"
+	if query != expectQuery {
+		t.Fatalf("query: %s, expect: %s", query, expectQuery)
+	}
+	expectArgs := []interface{}{"%adm%", []interface{}{int64(1), int64(2)}, int64(18), int64(30), 0, "efucloud", "%bob%", "%bob%", []interface{}{"guest"}}
+	if !reflect.DeepEqual(args, expectArgs) {
+		t.Fatalf("args: %#v, expect: %#v", args, expectArgs)
+	}
+}
+
+func TestBindQueryFilterIgnoresEmpty(t *testing.T) {
+	filter, err := BindQueryFilter(newQueryRequest("name=%20&status[]=&deletedAt=false"), &accountQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.IsEmpty() {
+		t.Fatalf("filter should be empty: %+v", filter)
+	}
+}
+
+func TestBindQueryFilterInvalid(t *testing.T) {
+	for _, rawQuery := range []string{"status[]=abc", "age[]=18", "age[]=1&age[]=2&age[]=3", "age[]=x&age[]=2"} {
+		_, err := BindQueryFilter(newQueryRequest(rawQuery), &accountQuery{})
+		if !errors.Is(err, ErrInvalidQueryParameter) {
+			t.Fatalf("query: %s, err: %v should be ErrInvalidQueryParameter", rawQuery, err)
+		}
+		if data := NewErrorData(err, I18nZH); data.ResponseCode != 400 {
+			t.Fatalf("query: %s, status: %d, expect 400", rawQuery, data.ResponseCode)
+		}
+	}
+}
+
+func TestQueryParameters(t *testing.T) {
+	params := QueryParameters(new(restful.WebService), accountQuery{})
+	if len(params) != 8 {
+		t.Fatalf("unexpected params: %d", len(params))
+	}
+	data := make(map[string]restful.ParameterData)
+	for _, param := range params {
+		data[param.Data().Name] = param.Data()
+	}
+	if item := data["name"]; item.DataType != "string" || item.Description != "名称" {
+		t.Fatalf("unexpected name param: %+v", item)
+	}
+	if item := data["status[]"]; item.DataType != "integer" || !item.AllowMultiple || item.CollectionFormat != restful.CollectionFormatMulti.String() {
+		t.Fatalf("unexpected status param: %+v", item)
+	}
+	if item := data["enabled"]; item.DataType != "boolean" || item.Description != "enabled" {
+		t.Fatalf("unexpected enabled param: %+v", item)
+	}
+}
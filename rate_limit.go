@@ -0,0 +1,323 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"github.com/efucloud/common/eauth"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"k8s.io/klog/v2"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitMetadataKey 路由元数据中限流配置的键，值为 RateLimit
+//
+//	ws.POST("/login").Metadata(common.RateLimitMetadataKey, common.RateLimit{Limit: 5, Period: time.Minute, Key: common.RateLimitByIP})
+const RateLimitMetadataKey = "RateLimit"
+
+// DefaultAccountClaimsAttributeKey 认证过滤器保存 eauth.AccountClaims 的默认请求属性键
+// 认证过滤器使用其他键时，需要在各过滤器选项的 ClaimsAttributeKey 中指定
+const DefaultAccountClaimsAttributeKey = "AccountClaims"
+
+// ErrTooManyRequests 请求被限流
+var ErrTooManyRequests = newLibraryError("statusTooManyRequests", http.StatusTooManyRequests)
+
+// GetAccountClaimsFromReq 获取认证过滤器以 DefaultAccountClaimsAttributeKey 保存的用户信息
+func GetAccountClaimsFromReq(req *restful.Request) (claims *eauth.AccountClaims, exist bool) {
+	return GetAccountClaimsFromReqAttribute(req, DefaultAccountClaimsAttributeKey)
+}
+
+// GetAccountClaimsFromReqAttribute 获取认证过滤器以 reqAttributeKey 保存的用户信息，支持值及指针
+func GetAccountClaimsFromReqAttribute(req *restful.Request, reqAttributeKey string) (claims *eauth.AccountClaims, exist bool) {
+	switch value := req.Attribute(reqAttributeKey).(type) {
+	case *eauth.AccountClaims:
+		return value, value != nil
+	case eauth.AccountClaims:
+		return &value, true
+	}
+	return nil, false
+}
+
+// ClientIP 获取客户端 IP，只有直接连接的地址属于 trustedProxies 时才使用 X-Forwarded-For 及 X-Real-Ip
+func ClientIP(req *http.Request, trustedProxies []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remote = req.RemoteAddr
+	}
+	trusted := func(value string) bool {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return false
+		}
+		for _, network := range trustedProxies {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	if !trusted(remote) {
+		return remote
+	}
+	// 从右向左查找第一个不受信任的地址
+	forwarded := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if len(ip) > 0 && !trusted(ip) {
+			return ip
+		}
+	}
+	if ip := strings.TrimSpace(req.Header.Get("X-Real-Ip")); len(ip) > 0 {
+		return ip
+	}
+	return remote
+}
+
+// parseTrustedProxies 解析受信任的反向代理网段
+func parseTrustedProxies(cidrs []string) (trustedProxies []*net.IPNet, err error) {
+	for _, cidr := range cidrs {
+		_, network, er := net.ParseCIDR(cidr)
+		if er != nil {
+			return nil, fmt.Errorf("parse trusted proxy: %s failed, err: %s", cidr, er.Error())
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+	return trustedProxies, nil
+}
+
+// RateLimitKeyFunc 生成限流的键，返回空字符串时不限流
+// claims 为以 RateLimitOptions.ClaimsAttributeKey 获取的用户信息，未认证时为空
+type RateLimitKeyFunc func(req *restful.Request, clientIP string, claims *eauth.AccountClaims) string
+
+// RateLimitByIP 按客户端 IP 限流
+func RateLimitByIP(req *restful.Request, clientIP string, claims *eauth.AccountClaims) string {
+	return "ip:" + clientIP
+}
+
+// RateLimitByUser 按组织及用户名限流，未认证时按客户端 IP 限流
+func RateLimitByUser(req *restful.Request, clientIP string, claims *eauth.AccountClaims) string {
+	if claims != nil && len(claims.Username) > 0 {
+		return fmt.Sprintf("user:%s/%s", claims.Org, claims.Username)
+	}
+	return RateLimitByIP(req, clientIP, claims)
+}
+
+// RateLimitByOrg 按组织限流，未认证时按客户端 IP 限流
+func RateLimitByOrg(req *restful.Request, clientIP string, claims *eauth.AccountClaims) string {
+	if claims != nil && len(claims.Org) > 0 {
+		return "org:" + claims.Org
+	}
+	return RateLimitByIP(req, clientIP, claims)
+}
+
+// RateLimitByAppClient 按应用 ClientID 限流，未认证时按客户端 IP 限流
+func RateLimitByAppClient(req *restful.Request, clientIP string, claims *eauth.AccountClaims) string {
+	if claims != nil && len(claims.AppClientID) > 0 {
+		return "app:" + claims.AppClientID
+	}
+	return RateLimitByIP(req, clientIP, claims)
+}
+
+// RateLimit 令牌桶限流配置，每个 Period 补充 Limit 个令牌，桶容量为 Burst
+type RateLimit struct {
+	Limit  int           `json:"limit" yaml:"limit" description:"周期内允许的请求数"`
+	Period time.Duration `json:"period" yaml:"period" description:"限流周期"`
+	// Burst 桶容量，默认等于 Limit
+	Burst int `json:"burst" yaml:"burst" description:"突发请求数"`
+	// Key 为空时使用 RateLimitOptions.Key
+	Key RateLimitKeyFunc `json:"-" yaml:"-"`
+}
+
+// Enabled Limit 及 Period 均大于 0 时限流
+func (l RateLimit) Enabled() bool {
+	return l.Limit > 0 && l.Period > 0
+}
+
+func (l RateLimit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Limit
+}
+
+// RateLimitResult 取令牌的结果
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter 被拒绝时距离下一个令牌的时间
+	RetryAfter time.Duration
+	// Reset 令牌桶补满的时间
+	Reset time.Duration
+}
+
+// RateLimitStore 令牌桶存储，多副本部署时可使用 Redis 等共享存储实现
+type RateLimitStore interface {
+	// Take 从 key 对应的令牌桶中取一个令牌
+	Take(ctx context.Context, key string, limit RateLimit) (result RateLimitResult, err error)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// full 令牌桶补满的时间，之后可以清理
+	full time.Time
+}
+
+// MemoryRateLimitStore 内存令牌桶存储，只适用于单副本部署
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+// sweep 每分钟清理一次已补满的令牌桶，需持有锁
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if now.After(bucket.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (result RateLimitResult, err error) {
+	now := time.Now()
+	burst := float64(limit.burst())
+	rate := float64(limit.Limit) / float64(limit.Period)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+	bucket, exist := s.buckets[key]
+	if !exist {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		s.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+float64(now.Sub(bucket.last))*rate)
+	bucket.last = now
+	result.Limit = limit.burst()
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - bucket.tokens) / rate)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = time.Duration((burst - bucket.tokens) / rate)
+	bucket.full = now.Add(result.Reset)
+	return result, nil
+}
+
+// RateLimitOptions 限流过滤器选项
+type RateLimitOptions struct {
+	// Default 未配置路由元数据时的限流，未启用时只对配置了元数据的路由限流
+	Default RateLimit
+	// Key 默认为 RateLimitByIP
+	Key RateLimitKeyFunc
+	// Store 默认为 NewMemoryRateLimitStore
+	Store RateLimitStore
+	// TrustedProxies 受信任的反向代理网段，用于从 X-Forwarded-For 获取客户端 IP
+	TrustedProxies []string
+	// Bundle 用于本地化被拒绝时的提示信息，必须设置
+	Bundle *i18n.Bundle
+	// LanguageAttributeKey 请求语言的属性键，默认为 DefaultLanguageAttributeKey
+	LanguageAttributeKey string
+	// ClaimsAttributeKey 认证过滤器保存用户信息的属性键，默认为 DefaultAccountClaimsAttributeKey
+	ClaimsAttributeKey string
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// RateLimitFilter 令牌桶限流过滤器，被拒绝时返回 429 及 Retry-After、RateLimit-* 响应头
+//
+//	filter, err := common.RateLimitFilter(common.RateLimitOptions{Default: common.RateLimit{Limit: 100, Period: time.Minute}, Key: common.RateLimitByUser, Bundle: bundle})
+//	container.Filter(filter)
+func RateLimitFilter(options RateLimitOptions) (restful.FilterFunction, error) {
+	if options.Key == nil {
+		options.Key = RateLimitByIP
+	}
+	if options.Store == nil {
+		options.Store = NewMemoryRateLimitStore()
+	}
+	if len(options.LanguageAttributeKey) == 0 {
+		options.LanguageAttributeKey = DefaultLanguageAttributeKey
+	}
+	if len(options.ClaimsAttributeKey) == 0 {
+		options.ClaimsAttributeKey = DefaultAccountClaimsAttributeKey
+	}
+	trustedProxies, err := parseTrustedProxies(options.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		limit, scope := options.Default, "default"
+		if route := req.SelectedRoute(); route != nil {
+			if override, ok := route.Metadata()[RateLimitMetadataKey].(RateLimit); ok {
+				limit, scope = override, route.Method()+" "+route.Path()
+			}
+		}
+		if !limit.Enabled() {
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		keyFunc := limit.Key
+		if keyFunc == nil {
+			keyFunc = options.Key
+		}
+		claims, _ := GetAccountClaimsFromReqAttribute(req, options.ClaimsAttributeKey)
+		key := keyFunc(req, ClientIP(req.Request, trustedProxies), claims)
+		if len(key) == 0 {
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		result, err := options.Store.Take(req.Request.Context(), scope+"|"+key, limit)
+		if err != nil {
+			// 存储不可用时不限流
+			klog.Errorf("take rate limit token failed, key: %s, err: %s", key, err.Error())
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		resp.AddHeader("RateLimit-Limit", strconv.Itoa(result.Limit))
+		resp.AddHeader("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		resp.AddHeader("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			resp.AddHeader("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			lang := GetLanguageFromReq(req, options.LanguageAttributeKey)
+			ResponseErrorMessage(req.Request.Context(), req, resp, options.Bundle,
+				ErrTooManyRequests.New(nil).ErrorData(lang))
+			return
+		}
+		chain.ProcessFilter(req, resp)
+	}, nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"github.com/efucloud/common/eauth"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/language"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	// 每 10ms 补充一个令牌，桶容量为 3
+	limit := RateLimit{Limit: 10, Period: 100 * time.Millisecond, Burst: 3}
+	for i := 0; i < 3; i++ {
+		result, _ := store.Take(context.Background(), "a", limit)
+		if !result.Allowed || result.Limit != 3 || result.Remaining != 2-i {
+			t.Fatalf("take: %d, unexpected result: %+v", i, result)
+		}
+	}
+	result, _ := store.Take(context.Background(), "a", limit)
+	if result.Allowed || result.Remaining != 0 {
+		t.Fatalf("burst should be exhausted: %+v", result)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > 10*time.Millisecond {
+		t.Fatalf("retry after: %s, expect (0, 10ms]", result.RetryAfter)
+	}
+	if result.Reset <= 20*time.Millisecond || result.Reset > 30*time.Millisecond {
+		t.Fatalf("reset: %s, expect (20ms, 30ms]", result.Reset)
+	}
+	if other, _ := store.Take(context.Background(), "b", limit); !other.Allowed {
+		t.Fatalf("buckets should be separated by key: %+v", other)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if result, _ = store.Take(context.Background(), "a", limit); !result.Allowed {
+			t.Fatalf("take: %d, tokens should be refilled: %+v", i, result)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if result, _ = store.Take(context.Background(), "a", limit); !result.Allowed || result.Remaining != 2 {
+		t.Fatalf("refill should not exceed burst: %+v", result)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "::1/128"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		expect    string
+	}{
+		{"direct", "203.0.113.9:1234", "", "", "203.0.113.9"},
+		{"untrusted proxy", "203.0.113.9:1234", "198.51.100.1", "198.51.100.2", "203.0.113.9"},
+		{"trusted proxy", "10.0.0.2:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"spoofed forwarded", "10.0.0.2:1234", "1.1.1.1, 198.51.100.1, 10.0.0.3", "", "198.51.100.1"},
+		{"only trusted forwarded", "10.0.0.2:1234", "10.0.0.3", "198.51.100.2", "198.51.100.2"},
+		{"no forwarded", "[::1]:1234", "", "", "::1"},
+	}
+	for _, item := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = item.remote
+		if len(item.forwarded) > 0 {
+			req.Header.Set("X-Forwarded-For", item.forwarded)
+		}
+		if len(item.realIP) > 0 {
+			req.Header.Set("X-Real-Ip", item.realIP)
+		}
+		if ip := ClientIP(req, trustedProxies); ip != item.expect {
+			t.Errorf("%s: ip: %s, expect: %s", item.name, ip, item.expect)
+		}
+	}
+	if _, err = parseTrustedProxies([]string{"10.0.0.1"}); err == nil {
+		t.Fatal("invalid cidr should be rejected")
+	}
+}
+
+func TestRateLimitFilter(t *testing.T) {
+	bundle := i18n.NewBundle(language.Chinese)
+	if err := AddDefaultMessages(bundle); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := RateLimitFilter(RateLimitOptions{Key: RateLimitByUser, Bundle: bundle, ClaimsAttributeKey: "Claims"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := new(restful.WebService)
+	ws.Route(ws.GET("/login").Metadata(RateLimitMetadataKey, RateLimit{Limit: 1, Period: time.Minute}).
+		To(func(req *restful.Request, resp *restful.Response) { resp.WriteHeader(http.StatusNoContent) }))
+	ws.Route(ws.GET("/other").To(func(req *restful.Request, resp *restful.Response) { resp.WriteHeader(http.StatusNoContent) }))
+	container := restful.NewContainer()
+	container.Add(ws)
+	container.Filter(func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		if username := req.Request.Header.Get("X-User"); len(username) > 0 {
+			req.SetAttribute("Claims", &eauth.AccountClaims{Org: "efucloud", Username: username})
+		}
+		chain.ProcessFilter(req, resp)
+	})
+	container.Filter(filter)
+	serve := func(path, username string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "203.0.113.9:1234"
+		if len(username) > 0 {
+			req.Header.Set("X-User", username)
+		}
+		recorder := httptest.NewRecorder()
+		container.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if recorder := serve("/login", "alice"); recorder.Code != http.StatusNoContent || recorder.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("code: %d, headers: %v", recorder.Code, recorder.Header())
+	}
+	recorder := serve("/login", "alice")
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "60" {
+		t.Fatalf("code: %d, headers: %v", recorder.Code, recorder.Header())
+	}
+	// 用户信息使用 ClaimsAttributeKey 获取，不同用户及未认证请求分别限流
+	for _, username := range []string{"bob", ""} {
+		if recorder = serve("/login", username); recorder.Code != http.StatusNoContent {
+			t.Fatalf("user: %q, code: %d", username, recorder.Code)
+		}
+	}
+	// 未配置元数据且未启用 Default 时不限流
+	for i := 0; i < 3; i++ {
+		if recorder = serve("/other", "alice"); recorder.Code != http.StatusNoContent || len(recorder.Header().Get("RateLimit-Limit")) > 0 {
+			t.Fatalf("code: %d, headers: %v", recorder.Code, recorder.Header())
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"go.uber.org/zap"
+	"k8s.io/klog/v2"
+	"net/http"
+	"runtime/debug"
+)
+
+// RecoverOptions 异常恢复过滤器选项
+type RecoverOptions struct {
+	Bundle *i18n.Bundle
+	// LanguageAttributeKey 语言在请求属性中的键，默认为 DefaultLanguageAttributeKey
+	LanguageAttributeKey string
+	// Logger 记录异常及调用栈的日志，为空时使用 klog
+	Logger *zap.SugaredLogger
+	// OnPanic 异常时的回调，如上报监控，可为空
+	OnPanic func(req *restful.Request, recovered interface{})
+}
+
+// PanicError 处理请求时发生的异常
+type PanicError struct {
+	Recovered interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Recovered)
+}
+
+// RecoverFilter 捕获后续过滤器及处理函数中的异常，记录调用栈后返回 500 ResponseError
+// 应在 RequestIDFilter 及语言协商过滤器之后第一个注册，响应已写入时只记录日志
+// http.ErrAbortHandler 会继续抛出，由 net/http 中断连接
+//
+//	container.Filter(common.RequestIDFilter)
+//	container.Filter(negotiator.Filter)
+//	container.Filter(common.RecoverFilter(common.RecoverOptions{Bundle: bundle, Logger: logger}))
+func RecoverFilter(options RecoverOptions) restful.FilterFunction {
+	if len(options.LanguageAttributeKey) == 0 {
+		options.LanguageAttributeKey = DefaultLanguageAttributeKey
+	}
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			stack := string(debug.Stack())
+			requestID := GetRequestIDFromReq(req)
+			if options.Logger != nil {
+				options.Logger.Errorw("request panic", "requestId", requestID, "method", req.Request.Method, "uri", req.Request.RequestURI,
+					"panic", fmt.Sprintf("%v", recovered), "stack", stack)
+			} else {
+				klog.ErrorS(nil, "request panic", "requestId", requestID, "method", req.Request.Method, "uri", req.Request.RequestURI,
+					"panic", fmt.Sprintf("%v", recovered), "stack", stack)
+			}
+			if options.OnPanic != nil {
+				options.OnPanic(req, recovered)
+			}
+			// 响应已写入时无法再返回错误信息
+			if resp.ContentLength() > 0 || resp.StatusCode() != http.StatusOK {
+				return
+			}
+			lang := GetLanguageFromReq(req, options.LanguageAttributeKey)
+			ResponseErrorMessage(req.Request.Context(), req, resp, options.Bundle, NewErrorData(&PanicError{Recovered: recovered}, lang))
+		}()
+		chain.ProcessFilter(req, resp)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"github.com/emicklei/go-restful/v3"
+	"go.uber.org/zap"
+	"net/http"
+	"regexp"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDAttributeKey 请求 ID 在请求属性中的键，请求上下文中使用 WithRequestID 及 GetRequestIDFromCtx 存取
+	RequestIDAttributeKey = "RequestID"
+)
+
+// requestIDContextKey 请求 ID 在上下文中的键，使用未导出的类型避免与其他包冲突
+type requestIDContextKey struct{}
+
+// requestIDPattern 接受的请求 ID 格式，不符合时重新生成，避免日志注入
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// WithRequestID 将请求 ID 写入上下文
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// GetRequestIDFromCtx 获取上下文中的请求 ID，不存在时返回空字符串
+func GetRequestIDFromCtx(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+// GetRequestIDFromReq 获取 RequestIDFilter 设置的请求 ID
+func GetRequestIDFromReq(req *restful.Request) string {
+	if requestID, ok := req.Attribute(RequestIDAttributeKey).(string); ok {
+		return requestID
+	}
+	return GetRequestIDFromCtx(req.Request.Context())
+}
+
+// LoggerWithRequestID 返回带有 requestId 字段的日志
+//
+//	common.LoggerWithRequestID(ctx, logger).Infof("create account: %s", name)
+func LoggerWithRequestID(ctx context.Context, logger *zap.SugaredLogger) *zap.SugaredLogger {
+	if requestID := GetRequestIDFromCtx(ctx); len(requestID) > 0 {
+		return logger.With("requestId", requestID)
+	}
+	return logger
+}
+
+// RequestIDFilter 使用请求头 X-Request-ID 或生成新的请求 ID，写入请求属性、请求上下文及响应头
+// 使用 RequestWithContext、HttpRequestWithContext、DoJSON 及 NewHTTPClient 创建的客户端发起请求时会继续传递
+//
+//	container.Filter(common.RequestIDFilter)
+func RequestIDFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	requestID := req.HeaderParameter(RequestIDHeader)
+	if !requestIDPattern.MatchString(requestID) {
+		requestID = NewID()
+	}
+	req.SetAttribute(RequestIDAttributeKey, requestID)
+	req.Request = req.Request.WithContext(WithRequestID(req.Request.Context(), requestID))
+	resp.AddHeader(RequestIDHeader, requestID)
+	chain.ProcessFilter(req, resp)
+}
+
+// setRequestIDHeader 请求上下文中有请求 ID 且请求头未设置时添加 X-Request-ID
+func setRequestIDHeader(req *http.Request) bool {
+	requestID := GetRequestIDFromCtx(req.Context())
+	if len(requestID) == 0 || len(req.Header.Get(RequestIDHeader)) > 0 {
+		return false
+	}
+	req.Header.Set(RequestIDHeader, requestID)
+	return true
+}
+
+// requestIDTransport 将请求上下文中的请求 ID 传递给下游服务
+type requestIDTransport struct {
+	next http.RoundTripper
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(GetRequestIDFromCtx(req.Context())) == 0 || len(req.Header.Get(RequestIDHeader)) > 0 {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	setRequestIDHeader(req)
+	return t.next.RoundTrip(req)
+}
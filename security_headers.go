@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"github.com/emicklei/go-restful/v3"
+	"net/http"
+	"strings"
+)
+
+// SecurityHeadersMetadataKey 路由元数据中覆盖安全响应头的键，值为 SecurityHeadersPolicy
+//
+//	ws.GET("/embed").Metadata(common.SecurityHeadersMetadataKey, common.SecurityHeadersPolicy{FrameOptions: "SAMEORIGIN"})
+const SecurityHeadersMetadataKey = "SecurityHeaders"
+
+// SecurityHeaderDisabled 路由覆盖时使用该值表示不返回对应的响应头
+const SecurityHeaderDisabled = "-"
+
+// SecurityHeadersPolicy 安全响应头策略，字段为空时不返回对应的响应头
+type SecurityHeadersPolicy struct {
+	ContentTypeOptions        string `json:"contentTypeOptions" yaml:"contentTypeOptions" description:"X-Content-Type-Options"`
+	ContentSecurityPolicy     string `json:"contentSecurityPolicy" yaml:"contentSecurityPolicy" description:"Content-Security-Policy"`
+	StrictTransportSecurity   string `json:"strictTransportSecurity" yaml:"strictTransportSecurity" description:"Strict-Transport-Security，只在 HTTPS 请求中返回"`
+	FrameOptions              string `json:"frameOptions" yaml:"frameOptions" description:"X-Frame-Options"`
+	ReferrerPolicy            string `json:"referrerPolicy" yaml:"referrerPolicy" description:"Referrer-Policy"`
+	PermissionsPolicy         string `json:"permissionsPolicy" yaml:"permissionsPolicy" description:"Permissions-Policy"`
+	CrossOriginOpenerPolicy   string `json:"crossOriginOpenerPolicy" yaml:"crossOriginOpenerPolicy" description:"Cross-Origin-Opener-Policy"`
+	CrossOriginEmbedderPolicy string `json:"crossOriginEmbedderPolicy" yaml:"crossOriginEmbedderPolicy" description:"Cross-Origin-Embedder-Policy"`
+}
+
+// DefaultSecurityHeadersPolicy 适用于 JSON API 的默认安全响应头
+func DefaultSecurityHeadersPolicy() SecurityHeadersPolicy {
+	return SecurityHeadersPolicy{
+		ContentTypeOptions:        "nosniff",
+		ContentSecurityPolicy:     "default-src 'none'; frame-ancestors 'none'",
+		StrictTransportSecurity:   "max-age=31536000; includeSubDomains",
+		FrameOptions:              "DENY",
+		ReferrerPolicy:            "no-referrer",
+		PermissionsPolicy:         "camera=(), microphone=(), geolocation=()",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginEmbedderPolicy: "require-corp",
+	}
+}
+
+// Merge 使用 override 中不为空的字段覆盖当前策略
+func (p SecurityHeadersPolicy) Merge(override SecurityHeadersPolicy) SecurityHeadersPolicy {
+	merge := func(value, override string) string {
+		if len(override) > 0 {
+			return override
+		}
+		return value
+	}
+	p.ContentTypeOptions = merge(p.ContentTypeOptions, override.ContentTypeOptions)
+	p.ContentSecurityPolicy = merge(p.ContentSecurityPolicy, override.ContentSecurityPolicy)
+	p.StrictTransportSecurity = merge(p.StrictTransportSecurity, override.StrictTransportSecurity)
+	p.FrameOptions = merge(p.FrameOptions, override.FrameOptions)
+	p.ReferrerPolicy = merge(p.ReferrerPolicy, override.ReferrerPolicy)
+	p.PermissionsPolicy = merge(p.PermissionsPolicy, override.PermissionsPolicy)
+	p.CrossOriginOpenerPolicy = merge(p.CrossOriginOpenerPolicy, override.CrossOriginOpenerPolicy)
+	p.CrossOriginEmbedderPolicy = merge(p.CrossOriginEmbedderPolicy, override.CrossOriginEmbedderPolicy)
+	return p
+}
+
+// Apply 将安全响应头写入 header
+func (p SecurityHeadersPolicy) Apply(header http.Header, req *http.Request) {
+	set := func(name, value string) {
+		if value == SecurityHeaderDisabled {
+			header.Del(name)
+		} else if len(value) > 0 {
+			header.Set(name, value)
+		}
+	}
+	set("X-Content-Type-Options", p.ContentTypeOptions)
+	set("Content-Security-Policy", p.ContentSecurityPolicy)
+	if req.TLS != nil || strings.EqualFold(req.Header.Get("X-Forwarded-Proto"), "https") {
+		set("Strict-Transport-Security", p.StrictTransportSecurity)
+	}
+	set("X-Frame-Options", p.FrameOptions)
+	set("Referrer-Policy", p.ReferrerPolicy)
+	set("Permissions-Policy", p.PermissionsPolicy)
+	set("Cross-Origin-Opener-Policy", p.CrossOriginOpenerPolicy)
+	set("Cross-Origin-Embedder-Policy", p.CrossOriginEmbedderPolicy)
+}
+
+// SecurityHeadersFilter 容器级安全响应头过滤器，路由可通过元数据 SecurityHeadersMetadataKey 覆盖策略
+//
+//	container.Filter(common.SecurityHeadersFilter(common.DefaultSecurityHeadersPolicy()))
+func SecurityHeadersFilter(policy SecurityHeadersPolicy) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		current := policy
+		if route := req.SelectedRoute(); route != nil {
+			if override, ok := route.Metadata()[SecurityHeadersMetadataKey].(SecurityHeadersPolicy); ok {
+				current = current.Merge(override)
+			}
+		}
+		current.Apply(resp.Header(), req.Request)
+		chain.ProcessFilter(req, resp)
+	}
+}
+
+// SecurityHeadersHandler 包装 http.Handler，用于未匹配到路由的 404/405 响应也返回安全响应头
+//
+//	server.Handler = common.SecurityHeadersHandler(policy, container)
+func SecurityHeadersHandler(policy SecurityHeadersPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy.Apply(w.Header(), r)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"k8s.io/klog/v2"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServerOptions HTTP 服务选项，Address 与 TLSAddress 至少设置一个
+type ServerOptions struct {
+	// Address HTTP 监听地址，如 :8080，为空时不监听
+	Address string `json:"address" yaml:"address" description:"HTTP监听地址"`
+	// TLSAddress HTTPS 监听地址，如 :8443，为空时不监听
+	TLSAddress string `json:"tlsAddress" yaml:"tlsAddress" description:"HTTPS监听地址"`
+	CertFile   string `json:"certFile" yaml:"certFile" description:"服务端证书文件"`
+	KeyFile    string `json:"keyFile" yaml:"keyFile" description:"服务端私钥文件"`
+	// TLSConfig 不为空时作为 HTTPS 的基础配置，如用于 mTLS 校验客户端证书
+	TLSConfig         *tls.Config   `json:"-" yaml:"-"`
+	ReadTimeout       time.Duration `json:"readTimeout" yaml:"readTimeout" description:"读取请求超时时间"`
+	ReadHeaderTimeout time.Duration `json:"readHeaderTimeout" yaml:"readHeaderTimeout" description:"读取请求头超时时间"`
+	WriteTimeout      time.Duration `json:"writeTimeout" yaml:"writeTimeout" description:"写入响应超时时间，导出等长请求需要适当调大"`
+	IdleTimeout       time.Duration `json:"idleTimeout" yaml:"idleTimeout" description:"空闲连接超时时间"`
+	MaxHeaderBytes    int           `json:"maxHeaderBytes" yaml:"maxHeaderBytes" description:"请求头最大字节数"`
+	// ShutdownDelay 收到停止信号并将就绪状态置为 false 后，等待负载均衡摘除流量的时间
+	ShutdownDelay time.Duration `json:"shutdownDelay" yaml:"shutdownDelay" description:"停止前等待时间"`
+	// ShutdownTimeout 等待处理中的请求完成的最长时间
+	ShutdownTimeout time.Duration `json:"shutdownTimeout" yaml:"shutdownTimeout" description:"停止超时时间"`
+	// ShutdownHookTimeout 处理中的请求完成后，等待所有停止钩子完成的最长时间，不与 ShutdownTimeout 共用
+	ShutdownHookTimeout time.Duration `json:"shutdownHookTimeout" yaml:"shutdownHookTimeout" description:"停止钩子超时时间"`
+}
+
+// DefaultServerOptions 默认监听 :8080
+func DefaultServerOptions() ServerOptions {
+	return ServerOptions{
+		Address:             ":8080",
+		ReadTimeout:         30 * time.Second,
+		ReadHeaderTimeout:   10 * time.Second,
+		WriteTimeout:        60 * time.Second,
+		IdleTimeout:         120 * time.Second,
+		MaxHeaderBytes:      1 << 20,
+		ShutdownTimeout:     30 * time.Second,
+		ShutdownHookTimeout: 10 * time.Second,
+	}
+}
+
+type shutdownHook struct {
+	name string
+	hook func(ctx context.Context) error
+}
+
+// Server 可优雅停止的 HTTP 服务
+type Server struct {
+	options ServerOptions
+	handler http.Handler
+	ready   atomic.Bool
+	mu      sync.Mutex
+	hooks   []shutdownHook
+}
+
+// NewServer 创建 HTTP 服务，handler 通常为 *restful.Container
+//
+//	server := common.NewServer(container, options)
+//	server.AddShutdownHook("database", func(ctx context.Context) error { return sqlDB.Close() })
+//	if err := server.Run(signals.SetupSignalHandler()); err != nil {
+//		klog.Fatal(err)
+//	}
+func NewServer(handler http.Handler, options ServerOptions) *Server {
+	defaults := DefaultServerOptions()
+	if options.ShutdownTimeout <= 0 {
+		options.ShutdownTimeout = defaults.ShutdownTimeout
+	}
+	if options.ShutdownHookTimeout <= 0 {
+		options.ShutdownHookTimeout = defaults.ShutdownHookTimeout
+	}
+	if options.MaxHeaderBytes <= 0 {
+		options.MaxHeaderBytes = defaults.MaxHeaderBytes
+	}
+	return &Server{options: options, handler: handler}
+}
+
+// AddShutdownHook 添加停止钩子，在处理中的请求完成后按添加顺序执行
+func (s *Server) AddShutdownHook(name string, hook func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, shutdownHook{name: name, hook: hook})
+}
+
+// Ready 服务是否就绪，开始监听后为 true，收到停止信号后为 false
+func (s *Server) Ready() bool {
+	return s.ready.Load()
+}
+
+// SetReady 设置就绪状态，如依赖暂时不可用时置为 false
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+func (s *Server) newHTTPServer() *http.Server {
+	return &http.Server{
+		Handler:           s.handler,
+		ReadTimeout:       s.options.ReadTimeout,
+		ReadHeaderTimeout: s.options.ReadHeaderTimeout,
+		WriteTimeout:      s.options.WriteTimeout,
+		IdleTimeout:       s.options.IdleTimeout,
+		MaxHeaderBytes:    s.options.MaxHeaderBytes,
+	}
+}
+
+// listen 先监听所有地址，任一地址失败时直接返回错误
+func (s *Server) listen() (servers []*http.Server, listeners []net.Listener, err error) {
+	closeAll := func() {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+	}
+	if len(s.options.Address) > 0 {
+		listener, er := net.Listen("tcp", s.options.Address)
+		if er != nil {
+			return nil, nil, fmt.Errorf("listen http address: %s failed, err: %s", s.options.Address, er.Error())
+		}
+		servers = append(servers, s.newHTTPServer())
+		listeners = append(listeners, listener)
+	}
+	if len(s.options.TLSAddress) > 0 {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if s.options.TLSConfig != nil {
+			tlsConfig = s.options.TLSConfig.Clone()
+		}
+		if len(s.options.CertFile) > 0 || len(s.options.KeyFile) > 0 {
+			cert, er := tls.LoadX509KeyPair(s.options.CertFile, s.options.KeyFile)
+			if er != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("load server certificate failed, err: %s", er.Error())
+			}
+			tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+		}
+		if len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil {
+			closeAll()
+			return nil, nil, errors.New("https server requires certFile/keyFile or TLSConfig with certificates")
+		}
+		if len(tlsConfig.NextProtos) == 0 {
+			tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		}
+		listener, er := net.Listen("tcp", s.options.TLSAddress)
+		if er != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("listen https address: %s failed, err: %s", s.options.TLSAddress, er.Error())
+		}
+		server := s.newHTTPServer()
+		server.TLSConfig = tlsConfig
+		servers = append(servers, server)
+		listeners = append(listeners, tls.NewListener(listener, tlsConfig))
+	}
+	if len(servers) == 0 {
+		return nil, nil, errors.New("server address and tlsAddress are both empty")
+	}
+	return servers, listeners, nil
+}
+
+// Run 启动服务并阻塞，stopCh 关闭或任一服务异常退出时优雅停止
+// 停止时先将就绪状态置为 false，等待 ShutdownDelay 后停止接收新请求，在 ShutdownTimeout 内等待处理中的请求完成
+// 之后在 ShutdownHookTimeout 内依次执行停止钩子
+func (s *Server) Run(stopCh <-chan struct{}) error {
+	servers, listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+	serveErr := make(chan error, len(servers))
+	for i := range servers {
+		go func(server *http.Server, listener net.Listener) {
+			klog.Infof("server listen on: %s", listener.Addr().String())
+			if er := server.Serve(listener); er != nil && !errors.Is(er, http.ErrServerClosed) {
+				serveErr <- fmt.Errorf("serve on %s failed, err: %s", listener.Addr().String(), er.Error())
+			}
+		}(servers[i], listeners[i])
+	}
+	s.SetReady(true)
+
+	var errs []error
+	select {
+	case <-stopCh:
+		klog.Info("received stop signal, shutting down server")
+	case er := <-serveErr:
+		klog.Errorf("%s, shutting down server", er.Error())
+		errs = append(errs, er)
+	}
+	s.SetReady(false)
+	if s.options.ShutdownDelay > 0 {
+		time.Sleep(s.options.ShutdownDelay)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.ShutdownTimeout)
+	defer cancel()
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if er := server.Shutdown(ctx); er != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("shutdown server failed, err: %s", er.Error()))
+				mu.Unlock()
+			}
+		}(server)
+	}
+	wg.Wait()
+
+	// 停止钩子使用独立的超时时间，不受等待请求完成所用时间的影响
+	hookCtx, hookCancel := context.WithTimeout(context.Background(), s.options.ShutdownHookTimeout)
+	defer hookCancel()
+	s.mu.Lock()
+	hooks := append([]shutdownHook{}, s.hooks...)
+	s.mu.Unlock()
+	for _, item := range hooks {
+		if er := item.hook(hookCtx); er != nil {
+			klog.Errorf("run shutdown hook: %s failed, err: %s", item.name, er.Error())
+			errs = append(errs, fmt.Errorf("shutdown hook: %s failed, err: %s", item.name, er.Error()))
+		}
+	}
+	klog.Info("server stopped")
+	return errors.Join(errs...)
+}
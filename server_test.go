@@ -0,0 +1,94 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	server := NewServer(http.NotFoundHandler(), ServerOptions{
+		Address:             "127.0.0.1:0",
+		ShutdownDelay:       50 * time.Millisecond,
+		ShutdownTimeout:     10 * time.Millisecond,
+		ShutdownHookTimeout: time.Second,
+	})
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	hook := func(name string, err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			// 停止钩子的超时时间不受 ShutdownDelay 及 ShutdownTimeout 影响
+			if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) < 500*time.Millisecond || ctx.Err() != nil {
+				t.Errorf("hook: %s, ctx should have its own budget, deadline: %s, err: %v", name, deadline, ctx.Err())
+			}
+			return err
+		}
+	}
+	server.AddShutdownHook("audit", hook("audit", nil))
+	server.AddShutdownHook("database", hook("database", errors.New("close failed")))
+	server.AddShutdownHook("cache", hook("cache", nil))
+
+	stopCh := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(stopCh)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !server.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("server should be ready after listening")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	close(stopCh)
+	for server.Ready() {
+		if time.Since(start) > 40*time.Millisecond {
+			t.Fatal("readiness should be flipped before ShutdownDelay")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	started := len(order)
+	mu.Unlock()
+	if started > 0 {
+		t.Fatal("hooks should run after ShutdownDelay")
+	}
+
+	err := <-done
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("server stopped after: %s, should wait ShutdownDelay", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "shutdown hook: database failed") {
+		t.Fatalf("err: %v should contain the failed hook", err)
+	}
+	if strings.Join(order, ",") != "audit,database,cache" {
+		t.Fatalf("hooks order: %v, expect the order they were added", order)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2022 The efucloud.com Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"github.com/emicklei/go-restful/v3"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+var sortNamer = schema.NamingStrategy{}
+
+// SortField 单个排序字段，Field 为 json 名称，Column 为数据库字段名称
+type SortField struct {
+	Field  string `json:"field" description:"字段名称"`
+	Column string `json:"column" description:"数据库字段"`
+	Desc   bool   `json:"desc" description:"是否倒序"`
+}
+
+type SortList []SortField
+
+// String 渲染为 db.Order 可用的排序语句，如 created_at DESC,name ASC
+func (s SortList) String() string {
+	var items []string
+	for _, item := range s {
+		if item.Desc {
+			items = append(items, fmt.Sprintf("%s DESC", item.Column))
+		} else {
+			items = append(items, fmt.Sprintf("%s ASC", item.Column))
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// OrderBy 渲染为 GORM 排序子句，通过 db.Clauses 使用，字段名由 GORM 负责转义
+func (s SortList) OrderBy() clause.OrderBy {
+	var orderBy clause.OrderBy
+	for _, item := range s {
+		orderBy.Columns = append(orderBy.Columns, clause.OrderByColumn{Column: clause.Column{Name: item.Column}, Desc: item.Desc})
+	}
+	return orderBy
+}
+
+// ParseSortSpec 解析排序参数，如 -createdAt,name 或兼容的 createdAt desc,name asc
+// 字段必须为 model 的 json 字段，spec 为空时使用 DefaultOrder
+func ParseSortSpec(spec string, model interface{}) (sorts SortList, err error) {
+	useDefault := len(strings.TrimSpace(spec)) == 0
+	if useDefault {
+		spec = DefaultOrder
+	}
+	columns := GetStructSortColumns(model)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		var sort SortField
+		parts := strings.Fields(item)
+		switch len(parts) {
+		case 1:
+			if strings.HasPrefix(item, "-") {
+				sort.Desc = true
+				item = strings.TrimPrefix(item, "-")
+			} else {
+				item = strings.TrimPrefix(item, "+")
+			}
+			sort.Field = item
+		case 2:
+			sort.Field = parts[0]
+			switch strings.ToLower(parts[1]) {
+			case "asc":
+			case "desc":
+				sort.Desc = true
+			default:
+				return nil, fmt.Errorf("order: %s, direction: %s is invalid", item, parts[1])
+			}
+		default:
+			return nil, fmt.Errorf("order: %s is invalid", item)
+		}
+		column, exist := columns[sort.Field]
+		if !exist {
+			if !useDefault {
+				return nil, fmt.Errorf("order field: %s is not allowed", sort.Field)
+			}
+			column = CamelString2Snake(sort.Field)
+		}
+		sort.Column = column
+		sorts = append(sorts, sort)
+	}
+	return sorts, nil
+}
+
+// GetStructSortColumns 获取模型允许排序的字段, key 为 json 名称, value 为数据库字段名称
+// 匿名嵌入的结构体字段会被展开，json 为 - 或 gorm 为 - 的字段会被忽略
+func GetStructSortColumns(model interface{}) (columns map[string]string) {
+	columns = make(map[string]string)
+	t := reflect.TypeOf(model)
+	if t == nil {
+		return
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for name, fieldName := range GetStructJsonFields(model) {
+		field, _ := t.FieldByName(fieldName.(string))
+		if len(name) == 0 || field.Anonymous || field.Tag.Get("gorm") == "-" {
+			continue
+		}
+		columns[name] = structFieldColumn(field)
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.Anonymous || len(field.Tag.Get("json")) > 0 {
+			continue
+		}
+		embedded := field.Type
+		if embedded.Kind() == reflect.Ptr {
+			embedded = embedded.Elem()
+		}
+		if embedded.Kind() != reflect.Struct {
+			continue
+		}
+		for name, column := range GetStructSortColumns(reflect.New(embedded).Interface()) {
+			if _, exist := columns[name]; !exist {
+				columns[name] = column
+			}
+		}
+	}
+	return columns
+}
+
+func structFieldColumn(field reflect.StructField) string {
+	for _, setting := range strings.Split(field.Tag.Get("gorm"), ";") {
+		kv := strings.SplitN(setting, ":", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "column") {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return sortNamer.ColumnName("", field.Name)
+}
+
+// GetRequestPaginationSorts 获取分页信息及经过校验的排序字段
+func GetRequestPaginationSorts(req *restful.Request, model interface{}) (current int, pageSize int, sorts SortList, err error) {
+	current = String2Int(req.QueryParameter("current"), DefaultPage)
+	pageSize = String2Int(req.QueryParameter("pageSize"), DefaultPageSize)
+	if sorts, err = ParseSortSpec(req.QueryParameter("order"), model); err != nil {
+		return current, pageSize, nil, ErrInvalidQueryParameter.Wrap(err, nil)
+	}
+	return current, pageSize, sorts, nil
+}
@@ -0,0 +1,35 @@
+package common
+
+import "testing"
+
+type sortBase struct {
+	ID        uint   `json:"id"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type sortModel struct {
+	sortBase
+	Name     string `json:"name"`
+	UserID   uint   `json:"userId"`
+	Nickname string `json:"nickname" gorm:"column:nick"`
+	Password string `json:"-"`
+}
+
+func TestParseSortSpec(t *testing.T) {
+	sorts, err := ParseSortSpec("-createdAt, name,userId desc,nickname", &sortModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sorts.String() != "created_at DESC,name ASC,user_id DESC,nick ASC" {
+		t.Fatalf("unexpected sorts: %s", sorts.String())
+	}
+	sorts, err = ParseSortSpec("", sortModel{})
+	if err != nil || sorts.String() != "id DESC" {
+		t.Fatalf("unexpected default sorts: %s, err: %v", sorts.String(), err)
+	}
+	for _, spec := range []string{"password", "name;drop table user", "id desc limit 1", "name up"} {
+		if _, err = ParseSortSpec(spec, sortModel{}); err == nil {
+			t.Fatalf("order: %s should be rejected", spec)
+		}
+	}
+}
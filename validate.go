@@ -18,6 +18,7 @@ package common
 
 import (
 	"fmt"
+	"github.com/go-playground/locales/en"
 	"github.com/go-playground/locales/zh"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
@@ -25,6 +26,7 @@ import (
 	zhtrans "github.com/go-playground/validator/v10/translations/zh"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -102,8 +104,14 @@ func (f FiledValidFailed) String() string {
 	for k, v := range f {
 		infos = append(infos, fmt.Sprintf("%s:%s", k, v))
 	}
+	sort.Strings(infos)
 	return strings.Join(infos, ";")
 }
+
+// Error 实现 error 接口，可通过 errors.As 从 ErrValidateFailed 中获取
+func (f FiledValidFailed) Error() string {
+	return f.String()
+}
 func (f FiledValidFailed) LocaleString(localeMap map[string]interface{}) string {
 	var infos []string
 	for key, value := range f {
@@ -498,7 +506,7 @@ func LoadValidateTranslator(lang string, validate *validator.Validate) (trans ut
 		trans = addTrans(I18nZH, validate, trans)
 		_ = zhtrans.RegisterDefaultTranslations(validate, trans)
 	case I18nEN:
-		uni := ut.New(zh.New(), zh.New())
+		uni := ut.New(en.New(), en.New())
 		trans, _ = uni.GetTranslator(lang)
 		trans = addTrans(I18nEN, validate, trans)
 		_ = entrans.RegisterDefaultTranslations(validate, trans)